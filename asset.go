@@ -0,0 +1,90 @@
+package gpkg
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// assetRules are the patterns given in a spec to select the asset of a
+// release, which take precedence over the heuristic. A pattern is a glob, or a
+// regular expression when enclosed in slashes, e.g. /-linux-(amd64|x86_64)/.
+type assetRules struct {
+	include string
+	exclude []string
+}
+
+// find returns the asset to download among assets. Excluded assets are never
+// selected. If several assets match include, or include is empty, the
+// heuristic chooses among them.
+func (ar *assetRules) find(p platform, assets []releaseAsset) (releaseAsset, bool) {
+	if ar == nil {
+		return findCompatibleAsset(p, assets)
+	}
+
+	var candidates []releaseAsset
+	for _, a := range assets {
+		if ok, _ := ar.judge(a); ok {
+			candidates = append(candidates, a)
+		}
+	}
+
+	if a, ok := findCompatibleAsset(p, candidates); ok {
+		return a, true
+	}
+	// The asset explicitly asked for need not be named after the platform.
+	if ar.include != "" && len(candidates) > 0 {
+		return candidates[0], true
+	}
+	return releaseAsset{}, false
+}
+
+// judge tells whether the rules let a be selected, and the reason if not.
+func (ar *assetRules) judge(a releaseAsset) (bool, string) {
+	if matchAnyAssetPattern(ar.exclude, a.name) {
+		return false, "excluded by asset_exclude"
+	}
+	if ar.include != "" && !matchAssetPattern(ar.include, a.name) {
+		return false, "does not match asset"
+	}
+	return true, ""
+}
+
+// isRegexpAssetPattern reports whether pattern is a regular expression rather
+// than a glob.
+func isRegexpAssetPattern(pattern string) bool {
+	return len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
+func matchAssetPattern(pattern, name string) bool {
+	if isRegexpAssetPattern(pattern) {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		return err == nil && re.MatchString(name)
+	}
+	ok, err := path.Match(pattern, name)
+	return err == nil && ok
+}
+
+func matchAnyAssetPattern(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if matchAssetPattern(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAssetPattern reports a pattern which would never match anything.
+func validateAssetPattern(pattern string) error {
+	var err error
+	if isRegexpAssetPattern(pattern) {
+		_, err = regexp.Compile(pattern[1 : len(pattern)-1])
+	} else {
+		_, err = path.Match(pattern, "")
+	}
+	if err != nil {
+		return fmt.Errorf("invalid asset pattern %q: %s", pattern, err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package gpkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchAssetPattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{"*-linux-amd64.tar.gz", "foo-linux-amd64.tar.gz", true},
+		{"*-linux-amd64.tar.gz", "foo-linux-amd64.tar.gz.sha256", false},
+		{"foo-?.tar.gz", "foo-1.tar.gz", true},
+		{"/-linux-(amd64|x86_64)/", "foo-linux-x86_64.tar.gz", true},
+		{"/^foo-linux/", "bar-foo-linux", false},
+		{"/(/", "(", false},
+		{"[", "[", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, matchAssetPattern(tt.pattern, tt.name))
+		})
+	}
+}
+
+func TestValidateAssetPattern(t *testing.T) {
+	require.NoError(t, validateAssetPattern("*-linux-*.tar.gz"))
+	require.NoError(t, validateAssetPattern("/musl|static/"))
+	require.Error(t, validateAssetPattern("[a-"))
+	require.Error(t, validateAssetPattern("/(/"))
+}
+
+func TestAssetRules_Find(t *testing.T) {
+	assets := []releaseAsset{
+		{name: "foo-linux-amd64-musl.tar.gz"},
+		{name: "foo-linux-amd64.tar.gz"},
+		{name: "foo-linux-amd64.tar.gz.sha256"},
+		{name: "foo-darwin-amd64.tar.gz"},
+		{name: "foo-universal.pkg"},
+	}
+	tests := []struct {
+		name     string
+		rules    *assetRules
+		expected string
+		found    bool
+	}{
+		{"heuristic", nil, "foo-linux-amd64-musl.tar.gz", true},
+		{"exclude", &assetRules{exclude: []string{"*musl*"}}, "foo-linux-amd64.tar.gz", true},
+		{"include", &assetRules{include: "*.tar.gz.sha256"}, "foo-linux-amd64.tar.gz.sha256", true},
+		{"include by regexp", &assetRules{include: `/-amd64\.tar\.gz$/`}, "foo-linux-amd64.tar.gz", true},
+		{"include not named after the platform", &assetRules{include: "*.pkg"}, "foo-universal.pkg", true},
+		{"heuristic among included", &assetRules{include: "*.tar.gz"}, "foo-linux-amd64-musl.tar.gz", true},
+		{"include and exclude", &assetRules{include: "*.tar.gz", exclude: []string{"*musl*"}}, "foo-linux-amd64.tar.gz", true},
+		{"everything excluded", &assetRules{exclude: []string{"*linux*"}}, "", false},
+		{"nothing included", &assetRules{include: "*.zip"}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.rules.find(platform{os: "linux", arch: "amd64"}, assets)
+			assert.Equal(t, tt.found, ok)
+			assert.Equal(t, tt.expected, got.name)
+		})
+	}
+}
+
+func TestCommonSpec_AssetRules(t *testing.T) {
+	spec := &CommonSpec{
+		Asset:        "*-linux.tar.gz",
+		AssetExclude: []string{"*musl*"},
+		AssetOverrides: map[string]string{
+			"darwin":       "*-macos.tar.gz",
+			"darwin/arm64": "*-macos-arm64.tar.gz",
+		},
+	}
+	tests := []struct {
+		goos, goarch string
+		expected     *assetRules
+	}{
+		{"linux", "amd64", &assetRules{include: "*-linux.tar.gz", exclude: []string{"*musl*"}}},
+		{"darwin", "amd64", &assetRules{include: "*-macos.tar.gz", exclude: []string{"*musl*"}}},
+		{"darwin", "arm64", &assetRules{include: "*-macos-arm64.tar.gz", exclude: []string{"*musl*"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.goos+"/"+tt.goarch, func(t *testing.T) {
+			assert.Equal(t, tt.expected, spec.assetRules(tt.goos, tt.goarch))
+		})
+	}
+
+	t.Run("no rules", func(t *testing.T) {
+		assert.Nil(t, (&CommonSpec{}).assetRules("linux", "amd64"))
+	})
+}
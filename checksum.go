@@ -0,0 +1,150 @@
+package gpkg
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// checksumSuffixes are the extensions of a checksum file dedicated to the asset
+// it is named after, e.g. foo.tar.gz.sha256.
+var checksumSuffixes = []string{".sha256", ".sha256sum"}
+
+// reChecksumsFile matches the name of a checksum file listing every asset of a
+// release, e.g. checksums.txt, SHA256SUMS or foo_1.0.0_checksums.txt.
+var reChecksumsFile = regexp.MustCompile(`(?i)(checksums?|sha256sums?)(\.txt)?$`)
+
+// findChecksumAsset returns the asset holding the checksum of the asset named
+// name. A file dedicated to it is preferred over a file listing every asset.
+func findChecksumAsset(name string, assets []releaseAsset) (releaseAsset, bool) {
+	for _, suffix := range checksumSuffixes {
+		for _, a := range assets {
+			if strings.EqualFold(a.name, name+suffix) {
+				return a, true
+			}
+		}
+	}
+	for _, a := range assets {
+		if reChecksumsFile.MatchString(a.name) {
+			return a, true
+		}
+	}
+	return releaseAsset{}, false
+}
+
+var (
+	reSHA256      = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+	reBSDChecksum = regexp.MustCompile(`^SHA256 \((.+)\) = ([0-9a-fA-F]{64})$`)
+)
+
+// isDedicatedChecksum reports whether the checksum file named sumName is
+// dedicated to the asset named name, rather than listing every asset.
+func isDedicatedChecksum(sumName, name string) bool {
+	for _, suffix := range checksumSuffixes {
+		if strings.EqualFold(sumName, name+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupChecksum returns the SHA-256 digest of the file named name listed in
+// data, or an empty string if it is not listed. Both the format of sha256sum
+// and the BSD one are understood. A line with a digest alone is taken as the
+// digest of name only if data is dedicated to it, as it could be of any file
+// otherwise.
+func lookupChecksum(data []byte, name string, dedicated bool) (string, error) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if m := reBSDChecksum.FindStringSubmatch(line); m != nil {
+			if path.Base(m[1]) == name {
+				return strings.ToLower(m[2]), nil
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !reSHA256.MatchString(fields[0]) {
+			continue
+		}
+		if len(fields) == 1 {
+			if dedicated {
+				return strings.ToLower(fields[0]), nil
+			}
+			continue
+		}
+		// sha256sum marks files read in binary mode with a leading asterisk.
+		if path.Base(strings.TrimPrefix(fields[1], "*")) == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", s.Err()
+}
+
+// releaseChecksum returns the SHA-256 digest published for asset in a checksum
+// file among assets. An empty digest is returned when there is no such file. A
+// file which does not list asset, such as one for another platform or of
+// another algorithm, leaves it unverified, which is reported as a warning.
+// headerFor returns the headers to fetch the file with, and may be nil.
+func releaseChecksum(asset releaseAsset, assets []releaseAsset, headerFor func(rawURL string) http.Header) (digest, warning string, err error) {
+	ca, ok := findChecksumAsset(asset.name, assets)
+	if !ok {
+		return "", "", nil
+	}
+
+	var header http.Header
+	if headerFor != nil {
+		header = headerFor(ca.url)
+	}
+	data, err := fetchSmallFile(ca.url, header)
+	if err != nil {
+		return "", "", err
+	}
+	digest, err = lookupChecksum(data, asset.name, isDedicatedChecksum(ca.name, asset.name))
+	if err != nil {
+		return "", "", err
+	}
+	if digest == "" {
+		warning = fmt.Sprintf("%s does not list a SHA-256 digest of %s, so it is not verified", ca.name, asset.name)
+	}
+	return digest, warning, nil
+}
+
+// digestReader computes the SHA-256 digest of what is read through it.
+type digestReader struct {
+	r io.Reader
+	h hash.Hash
+}
+
+func newDigestReader(r io.Reader) *digestReader {
+	return &digestReader{r: r, h: sha256.New()}
+}
+
+func (dr *digestReader) Read(p []byte) (int, error) {
+	n, err := dr.r.Read(p)
+	dr.h.Write(p[:n])
+	return n, err
+}
+
+// Sum returns the hex-encoded digest of the bytes read so far.
+func (dr *digestReader) Sum() string {
+	return hex.EncodeToString(dr.h.Sum(nil))
+}
+
+// verifyChecksum fails if the digest got differs from the expected one. An
+// empty expected digest means there is nothing to verify against.
+func verifyChecksum(name, expected, got string) error {
+	if expected == "" || strings.EqualFold(expected, got) {
+		return nil
+	}
+	return fmt.Errorf("checksum mismatch. asset=%s, expected=%s, got=%s", name, strings.ToLower(expected), got)
+}
@@ -0,0 +1,137 @@
+package gpkg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindChecksumAsset(t *testing.T) {
+	tests := []struct {
+		name     string
+		assets   []string
+		expected string
+		found    bool
+	}{
+		{"dedicated file", []string{"foo.tar.gz", "foo.tar.gz.sha256", "checksums.txt"}, "foo.tar.gz.sha256", true},
+		{"sha256sum suffix", []string{"foo.tar.gz", "foo.tar.gz.sha256sum"}, "foo.tar.gz.sha256sum", true},
+		{"checksums.txt", []string{"foo.tar.gz", "checksums.txt"}, "checksums.txt", true},
+		{"SHA256SUMS", []string{"foo.tar.gz", "SHA256SUMS", "SHA256SUMS.asc"}, "SHA256SUMS", true},
+		{"prefixed checksums", []string{"foo_1.0.0_checksums.txt", "foo.tar.gz"}, "foo_1.0.0_checksums.txt", true},
+		{"checksum of another asset", []string{"foo.tar.gz", "bar.tar.gz.sha256"}, "", false},
+		{"no checksum", []string{"foo.tar.gz"}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var assets []releaseAsset
+			for _, name := range tt.assets {
+				assets = append(assets, releaseAsset{name: name})
+			}
+			got, ok := findChecksumAsset("foo.tar.gz", assets)
+			assert.Equal(t, tt.found, ok)
+			assert.Equal(t, tt.expected, got.name)
+		})
+	}
+}
+
+func TestLookupChecksum(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+	other := strings.Repeat("cd", 32)
+	tests := []struct {
+		name      string
+		data      string
+		dedicated bool
+		expected  string
+	}{
+		{"sha256sum", fmt.Sprintf("%s  bar.tar.gz\n%s  foo.tar.gz\n", other, digest), false, digest},
+		{"binary mode", fmt.Sprintf("%s *foo.tar.gz\n", digest), false, digest},
+		{"path", fmt.Sprintf("%s  dist/foo.tar.gz\n", digest), false, digest},
+		{"bsd", fmt.Sprintf("SHA256 (bar.tar.gz) = %s\nSHA256 (foo.tar.gz) = %s\n", other, digest), false, digest},
+		{"digest alone", digest + "\n", true, digest},
+		{"digest alone in a shared file", digest + "\n", false, ""},
+		{"upper case", strings.ToUpper(digest) + "  foo.tar.gz\n", false, digest},
+		{"not listed", fmt.Sprintf("%s  bar.tar.gz\n", other), false, ""},
+		{"sha512", strings.Repeat("ab", 64) + "  foo.tar.gz\n", false, ""},
+		{"empty", "", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lookupChecksum([]byte(tt.data), "foo.tar.gz", tt.dedicated)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestReleaseChecksum(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/checksums.txt":
+			fmt.Fprintf(w, "%s  foo.tar.gz\n", digest)
+		case "/darwin_checksums.txt", "/foo.tar.gz.sha256":
+			fmt.Fprintf(w, "%s\n", digest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	asset := releaseAsset{name: "foo.tar.gz", url: srv.URL + "/foo.tar.gz"}
+	t.Run("published", func(t *testing.T) {
+		got, warning, err := releaseChecksum(asset, []releaseAsset{asset, {name: "checksums.txt", url: srv.URL + "/checksums.txt"}}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, digest, got)
+		assert.Empty(t, warning)
+	})
+	t.Run("dedicated file", func(t *testing.T) {
+		got, warning, err := releaseChecksum(asset, []releaseAsset{asset, {name: "foo.tar.gz.sha256", url: srv.URL + "/foo.tar.gz.sha256"}}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, digest, got)
+		assert.Empty(t, warning)
+	})
+	t.Run("not published", func(t *testing.T) {
+		got, warning, err := releaseChecksum(asset, []releaseAsset{asset}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "", got)
+		assert.Empty(t, warning)
+	})
+	t.Run("not listed", func(t *testing.T) {
+		// A digest alone in a shared file may be of any asset.
+		got, warning, err := releaseChecksum(asset, []releaseAsset{asset, {name: "darwin_checksums.txt", url: srv.URL + "/darwin_checksums.txt"}}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "", got)
+		assert.Contains(t, warning, "darwin_checksums.txt")
+	})
+	t.Run("unavailable", func(t *testing.T) {
+		_, _, err := releaseChecksum(asset, []releaseAsset{asset, {name: "SHA256SUMS", url: srv.URL + "/SHA256SUMS"}}, nil)
+		require.Error(t, err)
+	})
+}
+
+func TestDigestReader(t *testing.T) {
+	data := []byte("foo")
+	sum := sha256.Sum256(data)
+
+	dr := newDigestReader(bytes.NewReader(data))
+	_, err := io.Copy(io.Discard, dr)
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(sum[:]), dr.Sum())
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+	require.NoError(t, verifyChecksum("foo", "", digest))
+	require.NoError(t, verifyChecksum("foo", digest, digest))
+	require.NoError(t, verifyChecksum("foo", strings.ToUpper(digest), digest))
+	require.Error(t, verifyChecksum("foo", strings.Repeat("cd", 32), digest))
+}
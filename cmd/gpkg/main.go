@@ -2,11 +2,16 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
-	"time"
+	"sync"
+	"text/tabwriter"
 
 	"github.com/octarect/gpkg"
 	"github.com/spf13/cobra"
@@ -39,6 +44,9 @@ var (
 			if err := viper.Unmarshal(&cfg, gpkg.DecoderConfigOption(&cfg)); err != nil {
 				return err
 			}
+			if cfg.Dir, err = filepath.Abs(filepath.Dir(cfgPath)); err != nil {
+				return err
+			}
 
 			if cfg.CachePath == "" {
 				if cfg.CachePath, err = defaultCachePath(); err != nil {
@@ -87,6 +95,61 @@ var (
 			return commandUpdate()
 		},
 	}
+	lockCmd = &cobra.Command{
+		Use:   "lock",
+		Short: "Resolve packages and pin them in the lock file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commandLock()
+		},
+	}
+	uninstallCmd = &cobra.Command{
+		Use:   "uninstall <package>",
+		Short: "Uninstall a package",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commandUninstall(args[0])
+		},
+	}
+	rollbackCmd = &cobra.Command{
+		Use:   "rollback <package> [ref]",
+		Short: "Switch a package back to a version installed before",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ref := ""
+			if len(args) == 2 {
+				ref = args[1]
+			}
+			return commandRollback(args[0], ref)
+		},
+	}
+	pruneCmd = &cobra.Command{
+		Use:   "prune",
+		Short: "Uninstall packages which are no longer in the config",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commandPrune()
+		},
+	}
+	listCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List packages with their installed version and status",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commandList()
+		},
+	}
+	outdatedCmd = &cobra.Command{
+		Use:   "outdated",
+		Short: "List packages which have updates, exiting with 1 if any and 2 if a check fails",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := commandOutdated()
+			// Nothing but JSON is printed, and each failure has been reported
+			// on its own.
+			var oe *outdatedError
+			if outdatedJSON && errors.As(err, &oe) {
+				cmd.SilenceErrors = true
+			}
+			return err
+		},
+	}
 	loadCmd = &cobra.Command{
 		Use:   "load",
 		Short: "Generate script to load packages",
@@ -94,29 +157,64 @@ var (
 			return commandLoad()
 		},
 	}
-	cfgPath string
-	force   bool
+	explainAssetCmd = &cobra.Command{
+		Use:   "explain-asset <package>",
+		Short: "Explain how the asset of a package is selected",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commandExplainAsset(args[0])
+		},
+	}
+	cfgPath       string
+	force         bool
+	updateJobs    int
+	updateLocked  bool
+	updatePrune   bool
+	lockPlatforms []string
+	listJSON      bool
+	outdatedJSON  bool
+	explainOS     string
+	explainArch   string
 )
 
 func main() {
 	initCmd.Flags().BoolVar(&force, "force", false, "If true, all operations are executed without confirmation.")
 	rootCmd.AddCommand(initCmd)
 
+	updateCmd.Flags().IntVarP(&updateJobs, "jobs", "j", 0, fmt.Sprintf("Number of packages to update in parallel (default is the jobs config or %d)", gpkg.DefaultJobs))
+	updateCmd.Flags().BoolVar(&updateLocked, "locked", false, "Install exactly the artifacts pinned in the lock file, failing if any has drifted")
+	updateCmd.Flags().BoolVar(&updatePrune, "prune", false, "Uninstall packages which are no longer in the config after updating")
+	updateCmd.Flags().BoolVar(&force, "force", false, "If true, all operations are executed without confirmation.")
 	rootCmd.AddCommand(updateCmd)
+	uninstallCmd.Flags().BoolVar(&force, "force", false, "If true, all operations are executed without confirmation.")
+	rootCmd.AddCommand(uninstallCmd)
+	rootCmd.AddCommand(rollbackCmd)
+	pruneCmd.Flags().BoolVar(&force, "force", false, "If true, all operations are executed without confirmation.")
+	rootCmd.AddCommand(pruneCmd)
+	lockCmd.Flags().StringSliceVar(&lockPlatforms, "platform", nil, "Platform to lock artifacts for as os/arch, in addition to the host and the platforms already locked")
+	rootCmd.AddCommand(lockCmd)
+	listCmd.Flags().BoolVar(&listJSON, "json", false, "Print packages as JSON")
+	rootCmd.AddCommand(listCmd)
+	outdatedCmd.Flags().BoolVar(&outdatedJSON, "json", false, "Print every package checked as JSON")
+	rootCmd.AddCommand(outdatedCmd)
 	rootCmd.AddCommand(loadCmd)
+
+	explainAssetCmd.Flags().StringVar(&explainOS, "os", runtime.GOOS, "OS to select the asset for")
+	explainAssetCmd.Flags().StringVar(&explainArch, "arch", runtime.GOARCH, "Architecture to select the asset for")
+	rootCmd.AddCommand(explainAssetCmd)
 	rootCmd.AddCommand(versionCmd)
 
 	rootCmd.PersistentFlags().StringVar(&cfgPath, "config", "", "config file (default is $XDG_CONFIG_HOME/gpkg/config.yml)")
 
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+	if cmd, err := rootCmd.ExecuteC(); err != nil {
+		os.Exit(exitCode(cmd, err))
 	}
 
 	return
 }
 
 var errorFormat = `
-Error updating %s:
+Error %s %s:
   => %s
 `
 
@@ -126,55 +224,390 @@ func commandUpdate() error {
 	if err != nil {
 		return err
 	}
+
+	var lock *gpkg.LockData
+	if updateLocked {
+		if _, err := os.Stat(lockPath()); err != nil {
+			return fmt.Errorf("No lock file found. Run gpkg lock first. err=%v", err)
+		}
+		if lock, err = gpkg.LoadLockDataFromFile(lockPath()); err != nil {
+			return err
+		}
+	}
 	defer states.SaveToFile(statePath)
 
-	for _, spec := range cfg.Specs {
-		ch := make(chan *gpkg.Event)
-		bar := newProgressBar(spec.DisplayName())
-		go func() {
-			for range time.Tick(500 * time.Millisecond) {
-				select {
-				case ev, ok := <-ch:
-					if !ok {
-						bar.Finish()
-						return
-					}
-					switch ev.Type {
-					case gpkg.EventStarted:
-						fmt.Printf("%s\n", ev.Spec.DisplayName())
-					case gpkg.EventDownloadStarted:
-						d := ev.Data.(gpkg.EventDataDownload)
-						if d.CurrentRef == "" {
-							fmt.Printf("[INFO] New package: version=%s\n", d.NextRef)
-						} else {
-							fmt.Printf("[INFO] The package will be updated. current=%s, next=%s\n", d.CurrentRef, d.NextRef)
-						}
-						fmt.Printf("[INFO] Downloading...\n")
-						bar.Start()
-						bar.SetTotal(d.ContentLength)
-					case gpkg.EventDownloadCompleted:
-						bar.Finish()
-					case gpkg.EventPickStarted:
-						fmt.Printf("[INFO] Picking %s\n", ev.Spec.Common().Pick)
-					case gpkg.EventSkipped:
-						d := ev.Data.(gpkg.EventDataSkipped)
-						fmt.Printf("[INFO] %s is already up to date. current=%s\n", ev.Spec.Unique(), d.CurrentRef)
-					}
-				}
+	jobs := cfg.GetJobs()
+	if updateJobs > 0 {
+		jobs = updateJobs
+	}
+
+	r, err := newRenderer(os.Stdout, cfg.Specs)
+	if err != nil {
+		return err
+	}
+	ch := make(chan *gpkg.Event)
+	done := renderEvents(r, ch)
+
+	errs := make([]error, len(cfg.Specs))
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, spec := range cfg.Specs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, spec gpkg.PackageSpec) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if lock != nil {
+				errs[i] = gpkg.ReconcileLockedPackage(cfg.GetPackagesPath(), states, lock, spec, ch, r.Writer(spec))
+			} else {
+				errs[i] = gpkg.ReconcilePackage(cfg.GetPackagesPath(), states, spec, ch, r.Writer(spec))
 			}
-		}()
+		}(i, spec)
+	}
+	wg.Wait()
+	close(ch)
+	<-done
+	if err := r.Close(); err != nil {
+		return err
+	}
+
+	failed := 0
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		failed++
+		fmt.Fprintf(os.Stderr, errorFormat, "updating", cfg.Specs[i].DisplayName(), err)
+	}
+	if updatePrune {
+		if err := prune(states); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d packages failed to update", failed, len(cfg.Specs))
+	}
+
+	return nil
+}
+
+func commandLock() error {
+	path := lockPath()
+	lock, err := gpkg.LoadLockDataFromFile(path)
+	if err != nil {
+		return err
+	}
+
+	// Platforms locked by other machines are kept locked.
+	platforms := []string{gpkg.HostLockPlatform()}
+	seen := map[string]bool{platforms[0]: true}
+	for _, p := range append(lock.Platforms(), lockPlatforms...) {
+		if _, _, err := gpkg.ParseLockPlatform(p); err != nil {
+			return err
+		}
+		if !seen[p] {
+			seen[p] = true
+			platforms = append(platforms, p)
+		}
+	}
+
+	jobs := cfg.GetJobs()
+	locked := make([]*gpkg.LockedPackage, len(cfg.Specs))
+	errs := make([]error, len(cfg.Specs))
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, spec := range cfg.Specs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, spec gpkg.PackageSpec) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			locked[i], errs[i] = gpkg.LockPackage(spec, platforms)
+		}(i, spec)
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, spec := range cfg.Specs {
+		switch {
+		case errs[i] != nil:
+			// The package stays locked as before.
+			failed++
+			fmt.Fprintf(os.Stderr, errorFormat, "locking", spec.DisplayName(), errs[i])
+		case locked[i] != nil:
+			lock.Upsert(locked[i])
+			fmt.Printf("Locked %s at %s\n", spec.DisplayName(), locked[i].Ref)
+		}
+	}
+	lock.Retain(cfg.Specs)
+	if err := lock.SaveToFile(path); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d packages failed to lock", failed, len(cfg.Specs))
+	}
+	return nil
+}
+
+// lockPath returns the path of the lock file, which is next to the config
+// file.
+func lockPath() string {
+	return filepath.Join(filepath.Dir(cfgPath), gpkg.LockFileName)
+}
+
+func commandUninstall(name string) error {
+	statePath := filepath.Join(cfg.CachePath, "states.json")
+	states, err := gpkg.LoadStateDataFromFile(statePath)
+	if err != nil {
+		return err
+	}
+	spec, err := findInstalledSpec(states, name)
+	if err != nil {
+		return err
+	}
+
+	if !force {
+		yes, err := prompt(fmt.Sprintf("Uninstall %s?", spec.DisplayName()))
+		if err != nil {
+			return err
+		}
+		if !yes {
+			return fmt.Errorf("Aborted by user")
+		}
+	}
+	err = gpkg.UninstallPackage(&cfg, states, spec)
+	// The state is forgotten even if some files could not be removed.
+	if serr := states.SaveToFile(statePath); serr != nil {
+		return serr
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Uninstalled %s\n", spec.DisplayName())
+	if _, err := findSpec(name); err == nil {
+		fmt.Printf("%s is still in the config, and will be installed again by update.\n", name)
+	}
+	return nil
+}
+
+func commandRollback(name, ref string) error {
+	statePath := filepath.Join(cfg.CachePath, "states.json")
+	states, err := gpkg.LoadStateDataFromFile(statePath)
+	if err != nil {
+		return err
+	}
+	spec, err := findInstalledSpec(states, name)
+	if err != nil {
+		return err
+	}
+	_, prev, err := states.FindState(spec)
+	if err != nil {
+		return err
+	}
+
+	st, err := gpkg.RollbackPackage(states, spec, ref)
+	if err != nil {
+		return err
+	}
+	if err := states.SaveToFile(statePath); err != nil {
+		return err
+	}
+	fmt.Printf("Rolled back %s from %s to %s\n", spec.DisplayName(), prev.Ref, st.Ref)
+	return nil
+}
+
+func commandPrune() error {
+	statePath := filepath.Join(cfg.CachePath, "states.json")
+	states, err := gpkg.LoadStateDataFromFile(statePath)
+	if err != nil {
+		return err
+	}
+	err = prune(states)
+	if serr := states.SaveToFile(statePath); serr != nil {
+		return serr
+	}
+	return err
+}
+
+// prune uninstalls the packages which are no longer in the config after
+// confirmation.
+func prune(states *gpkg.StateData) error {
+	orphans, err := gpkg.FindOrphans(&cfg, states)
+	if err != nil {
+		return err
+	}
+	if len(orphans) == 0 {
+		fmt.Println("Nothing to prune.")
+		return nil
+	}
 
-		err = gpkg.ReconcilePackage(cfg.GetPackagesPath(), states, spec, ch, bar)
-		close(ch)
+	fmt.Println("The following packages are no longer in the config:")
+	for _, o := range orphans {
+		if o.InUse {
+			fmt.Printf("  %s (state only, %s is in use)\n", o.Name, o.Path)
+		} else {
+			fmt.Printf("  %s (%s)\n", o.Name, o.Path)
+		}
+	}
+	if !force {
+		yes, err := prompt(fmt.Sprintf("Remove %d packages?", len(orphans)))
 		if err != nil {
-			return fmt.Errorf(strings.TrimSpace(errorFormat), spec.DisplayName(), err)
+			return err
+		}
+		if !yes {
+			return fmt.Errorf("Aborted by user")
+		}
+	}
+
+	failed := 0
+	for _, o := range orphans {
+		if err := gpkg.RemoveOrphan(&cfg, states, o); err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, errorFormat, "pruning", o.Name, err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d packages failed to be pruned", failed, len(orphans))
+	}
+	return nil
+}
+
+func commandList() error {
+	states, err := loadStateData()
+	if err != nil {
+		return err
+	}
+	pkgs := gpkg.ListPackages(&cfg, states)
+
+	if listJSON {
+		if pkgs == nil {
+			pkgs = []*gpkg.PackageInfo{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(pkgs)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tFROM\tREF\tINSTALLED\tSTATUS\tINSTALLED AT\tPATH")
+	for _, p := range pkgs {
+		installedAt := "-"
+		if p.InstalledAt != nil {
+			installedAt = p.InstalledAt.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			p.Name, p.Source, orDash(p.Ref), orDash(p.InstalledRef), p.Status, installedAt, orDash(p.Path))
+	}
+	return w.Flush()
+}
+
+func commandOutdated() error {
+	states, err := loadStateData()
+	if err != nil {
+		return err
+	}
+
+	jobs := cfg.GetJobs()
+	infos := make([]*gpkg.UpdateInfo, len(cfg.Specs))
+	errs := make([]error, len(cfg.Specs))
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, spec := range cfg.Specs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, spec gpkg.PackageSpec) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			infos[i], errs[i] = gpkg.CheckUpdate(states, spec)
+		}(i, spec)
+	}
+	wg.Wait()
+
+	failed := 0
+	checked := []*gpkg.UpdateInfo{}
+	var outdated []*gpkg.UpdateInfo
+	for i, spec := range cfg.Specs {
+		if errs[i] != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, errorFormat, "checking", spec.DisplayName(), errs[i])
+			continue
+		}
+		checked = append(checked, infos[i])
+		if infos[i].Outdated {
+			outdated = append(outdated, infos[i])
+		}
+	}
+
+	if outdatedJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(checked); err != nil {
+			return err
+		}
+	} else if len(outdated) == 0 {
+		if failed == 0 {
+			fmt.Println("All packages are up to date.")
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tCURRENT\tNEXT")
+		for _, u := range outdated {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", u.Name, orDash(u.Current), u.Next)
+		}
+		if err := w.Flush(); err != nil {
+			return err
 		}
-		fmt.Println()
 	}
 
+	if failed > 0 || len(outdated) > 0 {
+		return &outdatedError{failed: failed, outdated: len(outdated), total: len(cfg.Specs)}
+	}
 	return nil
 }
 
+// outdatedError is returned by gpkg outdated when some packages have updates
+// or failed to be checked.
+type outdatedError struct {
+	failed   int
+	outdated int
+	total    int
+}
+
+func (e *outdatedError) Error() string {
+	if e.failed > 0 {
+		return fmt.Sprintf("%d of %d packages failed to be checked", e.failed, e.total)
+	}
+	return fmt.Sprintf("%d of %d packages have updates", e.outdated, e.total)
+}
+
+// exitCode returns the status to exit with when cmd failed with err. gpkg
+// outdated exits with 1 only if packages have updates, and with 2 if any check
+// failed, so that CI can tell a failure from updates.
+func exitCode(cmd *cobra.Command, err error) int {
+	if cmd != outdatedCmd {
+		return 1
+	}
+	var oe *outdatedError
+	if errors.As(err, &oe) && oe.failed == 0 {
+		return 1
+	}
+	return 2
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
 func commandLoad() error {
 	states, err := loadStateData()
 	if err != nil {
@@ -191,6 +624,62 @@ func commandLoad() error {
 	return nil
 }
 
+func commandExplainAsset(name string) error {
+	spec, err := findSpec(name)
+	if err != nil {
+		return err
+	}
+	ex, err := gpkg.ExplainAssets(spec, explainOS, explainArch)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s %s for %s/%s\n\n", spec.DisplayName(), ex.Ref, ex.OS, ex.Arch)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DECISION\tSCORE\tASSET\tREASONS")
+	selected := false
+	for _, d := range ex.Assets {
+		decision, score := "candidate", strconv.Itoa(d.Score)
+		switch {
+		case d.Selected:
+			decision = "selected"
+			selected = true
+		case d.Rejected:
+			decision, score = "rejected", "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", decision, score, d.Name, strings.Join(d.Reasons, ", "))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if !selected {
+		fmt.Println("\nNo compatible asset found.")
+	}
+	return nil
+}
+
+// findSpec returns the spec of the package named name, which is either its
+// display name or its unique name.
+func findSpec(name string) (gpkg.PackageSpec, error) {
+	for _, spec := range cfg.Specs {
+		if spec.DisplayName() == name || spec.Unique() == name {
+			return spec, nil
+		}
+	}
+	return nil, fmt.Errorf("No package named %s in the config", name)
+}
+
+// findInstalledSpec returns the spec of the installed package named name,
+// which may have been removed from the config.
+func findInstalledSpec(states *gpkg.StateData, name string) (gpkg.PackageSpec, error) {
+	for _, st := range states.States {
+		if st.Spec.DisplayName() == name || st.Spec.Unique() == name {
+			return st.Spec, nil
+		}
+	}
+	return nil, fmt.Errorf("No package named %s is installed", name)
+}
+
 func defaultConfigPath() (string, error) {
 	usrCfgDir, err := os.UserConfigDir()
 	if err != nil {
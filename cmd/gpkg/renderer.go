@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/mattn/go-isatty"
+	"github.com/octarect/gpkg"
+)
+
+// Renderer displays the progress of packages being reconciled concurrently.
+// Events from all packages are passed to Handle by a single goroutine.
+type Renderer interface {
+	Handle(ev *gpkg.Event)
+	// Writer returns a writer that receives the bytes downloaded for spec.
+	Writer(spec gpkg.PackageSpec) io.Writer
+	Close() error
+}
+
+// newRenderer returns a renderer drawing a live line per package when out is
+// a terminal, and a renderer logging plain lines otherwise.
+func newRenderer(out *os.File, specs []gpkg.PackageSpec) (Renderer, error) {
+	if isatty.IsTerminal(out.Fd()) || isatty.IsCygwinTerminal(out.Fd()) {
+		return newPoolRenderer(out, specs)
+	}
+	return newLogRenderer(out), nil
+}
+
+// renderEvents passes events received from ch to r until ch is closed.
+func renderEvents(r Renderer, ch <-chan *gpkg.Event) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ev := range ch {
+			r.Handle(ev)
+		}
+	}()
+	return done
+}
+
+const (
+	statusTemplate   = `{{string . "prefix"}}{{string . "status"}}`
+	downloadTemplate = `{{string . "prefix"}}{{counters . }} {{bar . }} {{percent . }} {{speed . }}{{with string . "suffix"}} {{.}}{{end}}`
+)
+
+type poolRenderer struct {
+	pool *pb.Pool
+	bars map[string]*pb.ProgressBar
+	// warnings are shown once the package is done, as the line is redrawn
+	// until then.
+	warnings map[string]string
+}
+
+func newPoolRenderer(out io.Writer, specs []gpkg.PackageSpec) (*poolRenderer, error) {
+	width := 0
+	for _, spec := range specs {
+		if n := len(spec.DisplayName()); n > width {
+			width = n
+		}
+	}
+
+	r := &poolRenderer{
+		bars:     make(map[string]*pb.ProgressBar, len(specs)),
+		warnings: make(map[string]string),
+	}
+	bars := make([]*pb.ProgressBar, 0, len(specs))
+	for _, spec := range specs {
+		bar := pb.New64(0)
+		bar.SetTemplateString(statusTemplate)
+		bar.Set(pb.Bytes, true)
+		bar.Set("prefix", fmt.Sprintf("%-*s  ", width, spec.DisplayName()))
+		bar.Set("status", "waiting")
+		r.bars[spec.Unique()] = bar
+		bars = append(bars, bar)
+	}
+
+	r.pool = pb.NewPool(bars...)
+	r.pool.Output = out
+	if err := r.pool.Start(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *poolRenderer) Handle(ev *gpkg.Event) {
+	bar, ok := r.bars[ev.Spec.Unique()]
+	if !ok {
+		return
+	}
+	switch ev.Type {
+	case gpkg.EventStarted:
+		bar.Set("status", "resolving")
+	case gpkg.EventDownloadStarted:
+		d := ev.Data.(gpkg.EventDataDownload)
+		bar.Set("suffix", refTransition(d.CurrentRef, d.NextRef))
+		bar.SetTotal(d.ContentLength)
+		bar.SetTemplateString(downloadTemplate)
+	case gpkg.EventDownloadCompleted:
+		bar.SetTemplateString(statusTemplate)
+		bar.Set("status", "extracted")
+	case gpkg.EventPickStarted:
+		bar.Set("status", fmt.Sprintf("picking %s", ev.Spec.Common().Pick))
+	case gpkg.EventCompleted:
+		status := fmt.Sprintf("installed %s", bar.Get("suffix"))
+		if w, ok := r.warnings[ev.Spec.Unique()]; ok {
+			status += fmt.Sprintf(" (warning: %s)", w)
+		}
+		bar.Set("status", status)
+		bar.Finish()
+	case gpkg.EventWarned:
+		r.warnings[ev.Spec.Unique()] = ev.Data.(gpkg.EventDataWarned).Message
+	case gpkg.EventSkipped:
+		d := ev.Data.(gpkg.EventDataSkipped)
+		bar.Set("status", fmt.Sprintf("up to date (%s)", d.CurrentRef))
+		bar.Finish()
+	case gpkg.EventFailed:
+		d := ev.Data.(gpkg.EventDataFailed)
+		bar.SetTemplateString(statusTemplate)
+		bar.Set("status", fmt.Sprintf("failed: %s", d.Err))
+		bar.Finish()
+	}
+}
+
+func (r *poolRenderer) Writer(spec gpkg.PackageSpec) io.Writer {
+	bar, ok := r.bars[spec.Unique()]
+	if !ok {
+		return io.Discard
+	}
+	return bar.NewProxyWriter(io.Discard)
+}
+
+func (r *poolRenderer) Close() error {
+	return r.pool.Stop()
+}
+
+type logRenderer struct {
+	out io.Writer
+}
+
+func newLogRenderer(out io.Writer) *logRenderer {
+	return &logRenderer{out: out}
+}
+
+func (r *logRenderer) Handle(ev *gpkg.Event) {
+	switch ev.Type {
+	case gpkg.EventStarted:
+		r.printf(ev, "Started")
+	case gpkg.EventDownloadStarted:
+		d := ev.Data.(gpkg.EventDataDownload)
+		if d.CurrentRef == "" {
+			r.printf(ev, "New package: version=%s", d.NextRef)
+		} else {
+			r.printf(ev, "The package will be updated. current=%s, next=%s", d.CurrentRef, d.NextRef)
+		}
+		r.printf(ev, "Downloading...")
+	case gpkg.EventDownloadCompleted:
+		r.printf(ev, "Downloaded")
+	case gpkg.EventPickStarted:
+		r.printf(ev, "Picking %s", ev.Spec.Common().Pick)
+	case gpkg.EventCompleted:
+		r.printf(ev, "Completed")
+	case gpkg.EventSkipped:
+		d := ev.Data.(gpkg.EventDataSkipped)
+		r.printf(ev, "Already up to date. current=%s", d.CurrentRef)
+	case gpkg.EventFailed:
+		d := ev.Data.(gpkg.EventDataFailed)
+		fmt.Fprintf(r.out, "[ERROR] %s: %s\n", ev.Spec.DisplayName(), d.Err)
+	case gpkg.EventWarned:
+		d := ev.Data.(gpkg.EventDataWarned)
+		fmt.Fprintf(r.out, "[WARN] %s: %s\n", ev.Spec.DisplayName(), d.Message)
+	}
+}
+
+func (r *logRenderer) printf(ev *gpkg.Event, format string, a ...interface{}) {
+	fmt.Fprintf(r.out, "[INFO] %s: %s\n", ev.Spec.DisplayName(), fmt.Sprintf(format, a...))
+}
+
+func (r *logRenderer) Writer(spec gpkg.PackageSpec) io.Writer {
+	return io.Discard
+}
+
+func (r *logRenderer) Close() error {
+	return nil
+}
+
+func refTransition(currentRef, nextRef string) string {
+	if currentRef == "" {
+		return nextRef
+	}
+	return fmt.Sprintf("%s -> %s", currentRef, nextRef)
+}
@@ -4,6 +4,7 @@ import (
 	"embed"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -15,15 +16,43 @@ import (
 	"github.com/mitchellh/mapstructure"
 )
 
+// DefaultJobs is the number of packages reconciled concurrently when no value
+// is configured.
+const DefaultJobs = 4
+
+// DefaultKeepVersions is the number of versions of a package kept installed,
+// including the current one, when no value is configured.
+const DefaultKeepVersions = 2
+
 type Config struct {
-	CachePath string        `json:"cache_path"`
-	Specs     []PackageSpec `json:"packages"`
+	CachePath    string        `json:"cache_path"`
+	Jobs         int           `json:"jobs"`
+	KeepVersions int           `json:"keep_versions"`
+	Specs        []PackageSpec `json:"packages"`
+
+	// Dir is the directory of the config file, which relative paths in the
+	// config are resolved against. The current directory is used if empty.
+	Dir string `json:"-"`
 }
 
 func (c *Config) GetPackagesPath() string {
 	return path.Join(c.CachePath, "packages")
 }
 
+func (c *Config) GetJobs() int {
+	if c.Jobs > 0 {
+		return c.Jobs
+	}
+	return DefaultJobs
+}
+
+func (c *Config) GetKeepVersions() int {
+	if c.KeepVersions > 0 {
+		return c.KeepVersions
+	}
+	return DefaultKeepVersions
+}
+
 type PackageSpec interface {
 	Common() *CommonSpec
 	Validate() error
@@ -33,10 +62,22 @@ type PackageSpec interface {
 }
 
 type CommonSpec struct {
-	From string `json:"from"`
-	Pick string `json:"pick,omitempty"`
-	Ref  string `json:"ref,omitempty"`
-	ID   string `json:"id,omitempty"`
+	From            string      `json:"from"`
+	Pick            string      `json:"pick,omitempty"`
+	Ref             string      `json:"ref,omitempty"`
+	ID              string      `json:"id,omitempty"`
+	StripComponents int         `json:"strip_components,omitempty"`
+	StripTopDir     bool        `json:"strip_top_dir,omitempty"`
+	SHA256          string      `json:"sha256,omitempty"`
+	Verify          *VerifySpec `json:"verify,omitempty"`
+	// Asset, AssetExclude and AssetOverrides select the asset of a release.
+	// AssetOverrides replaces Asset on the platforms given as os/arch or os.
+	Asset          string            `json:"asset,omitempty"`
+	AssetExclude   []string          `json:"asset_exclude,omitempty"`
+	AssetOverrides map[string]string `json:"asset_overrides,omitempty"`
+	// Prerelease lets latest, newest and version constraints resolve to
+	// prereleases.
+	Prerelease bool `json:"prerelease,omitempty"`
 
 	config *Config
 }
@@ -49,9 +90,67 @@ func (s *CommonSpec) Validate() error {
 	if s.From == "" {
 		return errors.New("from is required.")
 	}
+	if s.StripComponents < 0 {
+		return errors.New("strip_components must not be negative.")
+	}
+	if s.SHA256 != "" {
+		if !reSHA256.MatchString(s.SHA256) {
+			return errors.New("sha256 must be a hex-encoded SHA-256 digest.")
+		}
+		// The digest would not match any other release.
+		if isFloatingRef(s.Ref) {
+			return errors.New("sha256 requires a pinned ref.")
+		}
+	}
+	if isVersionConstraint(s.Ref) {
+		if _, err := parseVersionConstraint(s.Ref); err != nil {
+			return err
+		}
+	}
+	if s.Verify != nil {
+		if err := s.Verify.Validate(); err != nil {
+			return err
+		}
+	}
+	patterns := append([]string{s.Asset}, s.AssetExclude...)
+	for _, p := range s.AssetOverrides {
+		patterns = append(patterns, p)
+	}
+	for _, p := range patterns {
+		if err := validateAssetPattern(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateLiteralRef reports a ref or an option which only the sources of
+// releases resolve, for the other sources taking ref as it is.
+func (s *CommonSpec) validateLiteralRef() error {
+	if s.Ref == "newest" || isVersionConstraint(s.Ref) {
+		return fmt.Errorf("ref %q is only supported for releases.", s.Ref)
+	}
+	if s.Prerelease {
+		return errors.New("prerelease is only supported for releases.")
+	}
 	return nil
 }
 
+// assetRules returns the rules to select the asset for the platform, or nil
+// if the spec has none.
+func (s *CommonSpec) assetRules(goos, goarch string) *assetRules {
+	include := s.Asset
+	if p, ok := s.AssetOverrides[goos+"/"+goarch]; ok {
+		include = p
+	} else if p, ok := s.AssetOverrides[goos]; ok {
+		include = p
+	}
+	if include == "" && len(s.AssetExclude) == 0 {
+		return nil
+	}
+	return &assetRules{include: include, exclude: s.AssetExclude}
+}
+
 func (s *CommonSpec) DisplayName() string {
 	return s.ID
 }
@@ -99,6 +198,258 @@ func (s *GitHubReleaseSpec) Unique() string {
 	return s.Common().formatUnique(s.Repo)
 }
 
+type GitLabReleaseSpec struct {
+	*CommonSpec
+	Repo     string `json:"repo"`
+	BaseURL  string `json:"base_url,omitempty"`
+	TokenEnv string `json:"token_env,omitempty"`
+}
+
+func (s *GitLabReleaseSpec) Validate() error {
+	if s.Repo == "" {
+		return errors.New("repo is required.")
+	}
+	return nil
+}
+
+func (s *GitLabReleaseSpec) DisplayName() string {
+	if s.Common().ID != "" {
+		return s.Common().ID
+	} else {
+		return s.Repo
+	}
+}
+
+func (s *GitLabReleaseSpec) host() string {
+	if s.BaseURL == "" {
+		return "gitlab.com"
+	}
+	if u, err := url.Parse(s.BaseURL); err == nil && u.Host != "" {
+		return u.Host + u.Path
+	}
+	return s.BaseURL
+}
+
+func (s *GitLabReleaseSpec) PackagePath() string {
+	dir := strings.Replace(path.Join(s.host(), s.Repo), "/", "---", -1)
+	return s.Common().formatPackagePath(dir)
+}
+
+func (s *GitLabReleaseSpec) Unique() string {
+	return s.Common().formatUnique(path.Join(s.host(), s.Repo))
+}
+
+// Token returns the access token read from the environment variable named by
+// token_env, or GITLAB_TOKEN if it is not set.
+func (s *GitLabReleaseSpec) Token() string {
+	if s.TokenEnv != "" {
+		return os.Getenv(s.TokenEnv)
+	}
+	return os.Getenv("GITLAB_TOKEN")
+}
+
+type GiteaReleaseSpec struct {
+	*CommonSpec
+	Host     string `json:"host"`
+	Repo     string `json:"repo"`
+	TokenEnv string `json:"token_env,omitempty"`
+}
+
+func (s *GiteaReleaseSpec) Validate() error {
+	if s.Host == "" {
+		return errors.New("host is required.")
+	}
+	if s.Repo == "" {
+		return errors.New("repo is required.")
+	}
+	return nil
+}
+
+func (s *GiteaReleaseSpec) DisplayName() string {
+	if s.Common().ID != "" {
+		return s.Common().ID
+	} else {
+		return s.Repo
+	}
+}
+
+func (s *GiteaReleaseSpec) host() string {
+	if u, err := url.Parse(giteaBaseURL(s.Host)); err == nil && u.Host != "" {
+		return u.Host + u.Path
+	}
+	return s.Host
+}
+
+func (s *GiteaReleaseSpec) PackagePath() string {
+	dir := strings.Replace(path.Join(s.host(), s.Repo), "/", "---", -1)
+	return s.Common().formatPackagePath(dir)
+}
+
+func (s *GiteaReleaseSpec) Unique() string {
+	return s.Common().formatUnique(path.Join(s.host(), s.Repo))
+}
+
+// Token returns the access token read from the environment variable named by
+// token_env, or GITEA_TOKEN if it is not set.
+func (s *GiteaReleaseSpec) Token() string {
+	if s.TokenEnv != "" {
+		return os.Getenv(s.TokenEnv)
+	}
+	return os.Getenv("GITEA_TOKEN")
+}
+
+// URLSpec describes a package downloaded from a templated URL. The version is
+// given by ref, or resolved from version_url when ref is latest.
+type URLSpec struct {
+	*CommonSpec
+	URL         string            `json:"url"`
+	VersionURL  string            `json:"version_url,omitempty"`
+	VersionPath string            `json:"version_path,omitempty"`
+	OSMap       map[string]string `json:"os_map,omitempty"`
+	ArchMap     map[string]string `json:"arch_map,omitempty"`
+}
+
+func (s *URLSpec) Validate() error {
+	if s.URL == "" {
+		return errors.New("url is required.")
+	}
+	if s.Common().ID == "" {
+		return errors.New("id is required.")
+	}
+	if (s.Common().Ref == "" || s.Common().Ref == "latest") && s.VersionURL == "" {
+		return errors.New("either ref or version_url is required.")
+	}
+	return s.Common().validateLiteralRef()
+}
+
+func (s *URLSpec) PackagePath() string {
+	return s.Common().formatPackagePath("url")
+}
+
+func (s *URLSpec) Unique() string {
+	return s.Common().formatUnique("url")
+}
+
+// GitSpec describes a package installed from a snapshot of a git repository.
+// ref is a branch, a tag or a commit, and the default branch when omitted.
+type GitSpec struct {
+	*CommonSpec
+	URL string `json:"url"`
+}
+
+func (s *GitSpec) Validate() error {
+	if s.URL == "" {
+		return errors.New("url is required.")
+	}
+	// git would read them as options.
+	if strings.HasPrefix(s.URL, "-") {
+		return fmt.Errorf("invalid url %q", s.URL)
+	}
+	if strings.HasPrefix(s.Ref, "-") {
+		return fmt.Errorf("invalid ref %q", s.Ref)
+	}
+	return s.Common().validateLiteralRef()
+}
+
+func (s *GitSpec) DisplayName() string {
+	if s.Common().ID != "" {
+		return s.Common().ID
+	} else {
+		return s.URL
+	}
+}
+
+var reGitURLPrefix = regexp.MustCompile(`^([a-z+]+://)?([^@/]+@)?`)
+
+// location returns the location of the repository without a scheme, a user
+// and the .git suffix, e.g. github.com/foo/bar, which is the same for the
+// https and the ssh URL.
+func (s *GitSpec) location() string {
+	loc := reGitURLPrefix.ReplaceAllString(s.URL, "")
+	loc = strings.TrimSuffix(strings.TrimSuffix(loc, "/"), ".git")
+	return strings.Replace(strings.Trim(loc, "/"), ":", "/", -1)
+}
+
+// dir returns the location of the repository as a directory name, e.g.
+// github.com---foo---bar.
+func (s *GitSpec) dir() string {
+	return strings.Replace(s.location(), "/", "---", -1)
+}
+
+func (s *GitSpec) PackagePath() string {
+	return s.Common().formatPackagePath(s.dir())
+}
+
+// Unique is the same for any URL of the repository, as PackagePath is.
+func (s *GitSpec) Unique() string {
+	return s.Common().formatUnique(s.location())
+}
+
+// RepositoryCachePath returns the path to the bare clone of the repository.
+func (s *GitSpec) RepositoryCachePath() string {
+	return filepath.Join(s.Common().config.CachePath, "git", s.dir())
+}
+
+// LocalSpec describes a package installed from a directory, a binary or an
+// archive on the local filesystem. check is either hash or mtime.
+type LocalSpec struct {
+	*CommonSpec
+	Path  string `json:"path"`
+	Check string `json:"check,omitempty"`
+}
+
+func (s *LocalSpec) Validate() error {
+	if s.Path == "" {
+		return errors.New("path is required.")
+	}
+	switch s.Check {
+	case "", LocalCheckHash, LocalCheckMtime:
+	default:
+		return fmt.Errorf("check must be either %s or %s.", LocalCheckHash, LocalCheckMtime)
+	}
+	return s.Common().validateLiteralRef()
+}
+
+func (s *LocalSpec) DisplayName() string {
+	if s.Common().ID != "" {
+		return s.Common().ID
+	} else {
+		return s.Path
+	}
+}
+
+// resolvedPath returns the absolute path of the file to install. A relative
+// path is resolved against the directory of the config file, so that it does
+// not depend on where gpkg runs.
+func (s *LocalSpec) resolvedPath() string {
+	p := expandPath(s.Path)
+	if !filepath.IsAbs(p) {
+		dir := ""
+		if s.Common().config != nil {
+			dir = s.Common().config.Dir
+		}
+		if abs, err := filepath.Abs(filepath.Join(dir, p)); err == nil {
+			p = abs
+		}
+	}
+	return filepath.Clean(p)
+}
+
+// location returns the resolved path, so that every path to the same file is
+// the same package.
+func (s *LocalSpec) location() string {
+	return filepath.ToSlash(s.resolvedPath())
+}
+
+func (s *LocalSpec) PackagePath() string {
+	dir := "local---" + strings.Replace(strings.Trim(s.location(), "/"), "/", "---", -1)
+	return s.Common().formatPackagePath(dir)
+}
+
+func (s *LocalSpec) Unique() string {
+	return s.Common().formatUnique(s.location())
+}
+
 func SpecEqual(a, b PackageSpec) bool {
 	return a.Unique() == b.Unique()
 }
@@ -117,7 +468,7 @@ func DecoderConfigOption(cfg *Config) func(*mapstructure.DecoderConfig) {
 
 			m, _ := data.(map[string]interface{})
 			cs := &CommonSpec{}
-			if err := mapstructure.Decode(m, &cs); err != nil {
+			if err := decodeSpec(m, cs); err != nil {
 				return nil, err
 			}
 			if err := cs.Validate(); err != nil {
@@ -125,24 +476,46 @@ func DecoderConfigOption(cfg *Config) func(*mapstructure.DecoderConfig) {
 			}
 			cs.config = cfg
 
+			var spec PackageSpec
 			switch cs.From {
 			case "ghr":
-				ghr := &GitHubReleaseSpec{}
-				if err := mapstructure.Decode(m, &ghr); err != nil {
-					return nil, err
-				}
-				if err := ghr.Validate(); err != nil {
-					return nil, err
-				}
-				ghr.CommonSpec = cs
-				return ghr, nil
+				spec = &GitHubReleaseSpec{CommonSpec: cs}
+			case "glr":
+				spec = &GitLabReleaseSpec{CommonSpec: cs}
+			case "gitea":
+				spec = &GiteaReleaseSpec{CommonSpec: cs}
+			case "url":
+				spec = &URLSpec{CommonSpec: cs}
+			case "git":
+				spec = &GitSpec{CommonSpec: cs}
+			case "local":
+				spec = &LocalSpec{CommonSpec: cs}
 			default:
 				return nil, fmt.Errorf("invalid spec. from=%s", cs.From)
 			}
+			// The embedded CommonSpec is left untouched since it is not squashed.
+			if err := decodeSpec(m, spec); err != nil {
+				return nil, err
+			}
+			if err := spec.Validate(); err != nil {
+				return nil, err
+			}
+			return spec, nil
 		}
 	}
 }
 
+func decodeSpec(m map[string]interface{}, spec interface{}) error {
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		TagName: "json",
+		Result:  spec,
+	})
+	if err != nil {
+		return err
+	}
+	return dec.Decode(m)
+}
+
 //go:embed templates
 var tmplFS embed.FS
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"text/template"
 
@@ -69,6 +70,13 @@ func TestSpecEqual(t *testing.T) {
 	}{
 		{NewNopSpec("foo"), NewNopSpec("foo"), true},
 		{NewNopSpec("foo"), NewNopSpec("bar"), false},
+		{newLocalTestSpec("./tools"), newLocalTestSpec("tools"), true},
+		{newLocalTestSpec("tools/"), newLocalTestSpec("tools"), true},
+		{newLocalTestSpec("tools"), newLocalTestSpec("bin"), false},
+		{newLocalTestSpec("/srv/a"), newLocalTestSpec("srv/a"), false},
+		{newLocalTestSpec("/etc/gpkg/tools"), newLocalTestSpec("tools"), true},
+		{newGitTestSpec("https://github.com/foo/bar.git"), newGitTestSpec("git@github.com:foo/bar"), true},
+		{newGitTestSpec("https://github.com/foo/bar"), newGitTestSpec("https://github.com/foo/baz"), false},
 	}
 
 	for i, tt := range tests {
@@ -79,3 +87,156 @@ func TestSpecEqual(t *testing.T) {
 		})
 	}
 }
+
+func newLocalTestSpec(path string) *LocalSpec {
+	return &LocalSpec{CommonSpec: &CommonSpec{From: "local", config: &Config{CachePath: "/tmp", Dir: "/etc/gpkg"}}, Path: path}
+}
+
+func newGitTestSpec(url string) *GitSpec {
+	return &GitSpec{CommonSpec: &CommonSpec{From: "git", config: &Config{CachePath: "/tmp"}}, URL: url}
+}
+
+func TestConfig_GetJobs(t *testing.T) {
+	tests := []struct {
+		jobs     int
+		expected int
+	}{
+		{0, DefaultJobs},
+		{-1, DefaultJobs},
+		{1, 1},
+		{8, 8},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("jobs=%d", tt.jobs), func(t *testing.T) {
+			c := &Config{Jobs: tt.jobs}
+			assert.Equal(t, tt.expected, c.GetJobs())
+		})
+	}
+}
+
+func TestCommonSpec_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    *CommonSpec
+		recvErr bool
+	}{
+		{"from", &CommonSpec{From: "ghr"}, false},
+		{"strip components", &CommonSpec{From: "ghr", StripComponents: 1}, false},
+		{"no from", &CommonSpec{}, true},
+		{"negative strip components", &CommonSpec{From: "ghr", StripComponents: -1}, true},
+		{"asset patterns", &CommonSpec{From: "ghr", Asset: "*.tar.gz", AssetExclude: []string{"/musl/"}}, false},
+		{"invalid asset pattern", &CommonSpec{From: "ghr", Asset: "[a-"}, true},
+		{"invalid asset override", &CommonSpec{From: "ghr", AssetOverrides: map[string]string{"linux": "/(/"}}, true},
+		{"version constraint", &CommonSpec{From: "ghr", Ref: ">=0.30, <0.40"}, false},
+		{"invalid version constraint", &CommonSpec{From: "ghr", Ref: "~foo"}, true},
+		{"sha256 with version constraint", &CommonSpec{From: "ghr", Ref: "~1.4", SHA256: strings.Repeat("0", 64)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.Validate()
+			if tt.recvErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestURLSpec_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    *URLSpec
+		recvErr bool
+	}{
+		{"pinned", &URLSpec{CommonSpec: &CommonSpec{ID: "foo", Ref: "1.0"}, URL: "https://example.com"}, false},
+		{"version url", &URLSpec{CommonSpec: &CommonSpec{ID: "foo"}, URL: "https://example.com", VersionURL: "https://example.com/VERSION"}, false},
+		{"no url", &URLSpec{CommonSpec: &CommonSpec{ID: "foo", Ref: "1.0"}}, true},
+		{"no id", &URLSpec{CommonSpec: &CommonSpec{Ref: "1.0"}, URL: "https://example.com"}, true},
+		{"no version", &URLSpec{CommonSpec: &CommonSpec{ID: "foo", Ref: "latest"}, URL: "https://example.com"}, true},
+		{"version constraint", &URLSpec{CommonSpec: &CommonSpec{ID: "foo", Ref: "1.2.x"}, URL: "https://example.com"}, true},
+		{"newest", &URLSpec{CommonSpec: &CommonSpec{ID: "foo", Ref: "newest"}, URL: "https://example.com", VersionURL: "https://example.com/VERSION"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.Validate()
+			if tt.recvErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGitSpec_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    *GitSpec
+		recvErr bool
+	}{
+		{"url", &GitSpec{CommonSpec: &CommonSpec{}, URL: "https://github.com/foo/bar"}, false},
+		{"branch", &GitSpec{CommonSpec: &CommonSpec{Ref: "main"}, URL: "https://github.com/foo/bar"}, false},
+		{"no url", &GitSpec{CommonSpec: &CommonSpec{}}, true},
+		{"url as an option", &GitSpec{CommonSpec: &CommonSpec{}, URL: "--upload-pack=touch /tmp/x"}, true},
+		{"ref as an option", &GitSpec{CommonSpec: &CommonSpec{Ref: "--upload-pack=touch /tmp/x"}, URL: "https://github.com/foo/bar"}, true},
+		{"version constraint", &GitSpec{CommonSpec: &CommonSpec{Ref: "~1.4"}, URL: "https://github.com/foo/bar"}, true},
+		{"prerelease", &GitSpec{CommonSpec: &CommonSpec{Prerelease: true}, URL: "https://github.com/foo/bar"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.Validate()
+			if tt.recvErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGitSpec_PackagePath(t *testing.T) {
+	cfg := &Config{CachePath: "/tmp"}
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"https://github.com/foo/bar.git", "github.com---foo---bar"},
+		{"https://github.com/foo/bar", "github.com---foo---bar"},
+		{"ssh://git@example.com:2222/foo/bar.git", "example.com---2222---foo---bar"},
+		{"git@github.com:foo/bar.git", "github.com---foo---bar"},
+		{"file:///srv/git/bar.git", "srv---git---bar"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			s := &GitSpec{CommonSpec: &CommonSpec{config: cfg}, URL: tt.url}
+			assert.Equal(t, filepath.Join(cfg.GetPackagesPath(), tt.expected), s.PackagePath())
+			assert.Equal(t, filepath.Join(cfg.CachePath, "git", tt.expected), s.RepositoryCachePath())
+		})
+	}
+}
+
+func TestLocalSpec_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    *LocalSpec
+		recvErr bool
+	}{
+		{"path", &LocalSpec{CommonSpec: &CommonSpec{}, Path: "/opt/foo"}, false},
+		{"check", &LocalSpec{CommonSpec: &CommonSpec{}, Path: "/opt/foo", Check: LocalCheckMtime}, false},
+		{"no path", &LocalSpec{CommonSpec: &CommonSpec{}}, true},
+		{"invalid check", &LocalSpec{CommonSpec: &CommonSpec{}, Path: "/opt/foo", Check: "size"}, true},
+		{"version constraint", &LocalSpec{CommonSpec: &CommonSpec{Ref: "^1"}, Path: "/opt/foo"}, true},
+		{"prerelease", &LocalSpec{CommonSpec: &CommonSpec{Prerelease: true}, Path: "/opt/foo"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.Validate()
+			if tt.recvErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -9,6 +9,8 @@ const (
 	EventDownloadCompleted
 	EventPickStarted
 	EventSkipped
+	EventFailed
+	EventWarned
 )
 
 type Event struct {
@@ -84,3 +86,31 @@ func (b *EventBuilder) skipped(currentRef string) *Event {
 		},
 	}
 }
+
+type EventDataFailed struct {
+	Err error
+}
+
+func (b *EventBuilder) failed(err error) *Event {
+	return &Event{
+		Type: EventFailed,
+		Spec: b.spec,
+		Data: EventDataFailed{
+			Err: err,
+		},
+	}
+}
+
+type EventDataWarned struct {
+	Message string
+}
+
+func (b *EventBuilder) warned(message string) *Event {
+	return &Event{
+		Type: EventWarned,
+		Spec: b.spec,
+		Data: EventDataWarned{
+			Message: message,
+		},
+	}
+}
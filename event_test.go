@@ -2,6 +2,7 @@ package gpkg
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"testing"
 
@@ -94,3 +95,10 @@ func TestEventBuilder_skipped(t *testing.T) {
 	}
 	checkDiff(t, Event{}, expected, got, "Spec")
 }
+
+func TestEventBuilder_failed(t *testing.T) {
+	err := errors.New("failed")
+	got := defaultTestEventBuilder.failed(err)
+	assert.Equal(t, EventFailed, got.Type)
+	assert.Equal(t, EventDataFailed{Err: err}, got.Data)
+}
@@ -0,0 +1,90 @@
+package gpkg
+
+import (
+	"fmt"
+	"sort"
+)
+
+// AssetExplanation tells how the asset of a package is selected among the
+// assets of the release its ref resolves to.
+type AssetExplanation struct {
+	Ref    string           `json:"ref"`
+	OS     string           `json:"os"`
+	Arch   string           `json:"arch"`
+	Assets []*AssetDecision `json:"assets"`
+}
+
+// AssetDecision is the judgement on a release asset. Score only matters among
+// the assets which are not rejected.
+type AssetDecision struct {
+	Name     string   `json:"name"`
+	Score    int      `json:"score"`
+	Selected bool     `json:"selected"`
+	Rejected bool     `json:"rejected"`
+	Reasons  []string `json:"reasons"`
+}
+
+// ExplainAssets fetches the release spec resolves to and judges every asset
+// of it for goos and goarch, the selected one first.
+func ExplainAssets(spec PackageSpec, goos, goarch string) (*AssetExplanation, error) {
+	src, err := getSource(spec)
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := src.(assetLister)
+	if !ok {
+		return nil, fmt.Errorf("%s is not installed from release assets", spec.DisplayName())
+	}
+	tag, assets, err := lister.listAssets()
+	if err != nil {
+		return nil, err
+	}
+
+	p := newPlatform(goos, goarch)
+	rules := spec.Common().assetRules(goos, goarch)
+	selected, found := rules.find(p, assets)
+
+	decisions := make([]*AssetDecision, 0, len(assets))
+	for _, r := range rankAssets(p, assets) {
+		rejections := r.rejections
+		if rules != nil {
+			if ok, reason := rules.judge(r.asset); !ok {
+				rejections = append([]string{reason}, rejections...)
+			}
+		}
+
+		d := &AssetDecision{
+			Name:  r.asset.name,
+			Score: r.score,
+		}
+		switch {
+		case found && r.asset == selected:
+			d.Selected = true
+			d.Reasons = r.notes
+			if len(rejections) > 0 {
+				// Only an asset matching the pattern explicitly given is
+				// selected in spite of the heuristic.
+				d.Reasons = []string{"matches asset"}
+			}
+		case len(rejections) > 0:
+			d.Rejected = true
+			d.Reasons = rejections
+		default:
+			d.Reasons = r.notes
+		}
+		decisions = append(decisions, d)
+	}
+	sort.SliceStable(decisions, func(i, j int) bool {
+		if decisions[i].Selected != decisions[j].Selected {
+			return decisions[i].Selected
+		}
+		return !decisions[i].Rejected && decisions[j].Rejected
+	})
+
+	return &AssetExplanation{
+		Ref:    tag,
+		OS:     goos,
+		Arch:   goarch,
+		Assets: decisions,
+	}, nil
+}
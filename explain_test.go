@@ -0,0 +1,74 @@
+package gpkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplainAssets(t *testing.T) {
+	srv := newGiteaTestServer(t, "owner/foo", "", []*giteaRelease{
+		newGiteaTestRelease("v1.0.0",
+			"foo-v1.0.0-linux-amd64-musl.tar.gz",
+			"foo-v1.0.0-linux-amd64.tar.gz",
+			"foo-v1.0.0-linux-amd64.tar.gz.sha256",
+			"foo-v1.0.0-darwin-arm64.tar.gz",
+		),
+	})
+	newSpec := func(cs *CommonSpec) *GiteaReleaseSpec {
+		cs.From = "gitea"
+		cs.Ref = "latest"
+		return &GiteaReleaseSpec{CommonSpec: cs, Host: srv.URL, Repo: "owner/foo"}
+	}
+
+	t.Run("heuristic", func(t *testing.T) {
+		ex, err := ExplainAssets(newSpec(&CommonSpec{}), "darwin", "arm64")
+		require.NoError(t, err)
+		assert.Equal(t, "v1.0.0", ex.Ref)
+		require.Len(t, ex.Assets, 4)
+		assert.Equal(t, "foo-v1.0.0-darwin-arm64.tar.gz", ex.Assets[0].Name)
+		assert.True(t, ex.Assets[0].Selected)
+		for _, d := range ex.Assets[1:] {
+			assert.False(t, d.Selected)
+			assert.True(t, d.Rejected, d.Name)
+			assert.Contains(t, d.Reasons, "OS mismatch")
+		}
+	})
+
+	t.Run("excluded by pattern", func(t *testing.T) {
+		ex, err := ExplainAssets(newSpec(&CommonSpec{AssetExclude: []string{"*musl*"}}), "linux", "amd64")
+		require.NoError(t, err)
+		decisions := map[string]*AssetDecision{}
+		for _, d := range ex.Assets {
+			decisions[d.Name] = d
+		}
+		assert.True(t, decisions["foo-v1.0.0-linux-amd64.tar.gz"].Selected)
+		assert.True(t, decisions["foo-v1.0.0-linux-amd64-musl.tar.gz"].Rejected)
+		assert.Equal(t, "excluded by asset_exclude", decisions["foo-v1.0.0-linux-amd64-musl.tar.gz"].Reasons[0])
+		assert.Contains(t, decisions["foo-v1.0.0-linux-amd64.tar.gz.sha256"].Reasons, "checksum, signature or SBOM file")
+	})
+
+	t.Run("selected by pattern", func(t *testing.T) {
+		ex, err := ExplainAssets(newSpec(&CommonSpec{Asset: "*.sha256"}), "linux", "amd64")
+		require.NoError(t, err)
+		assert.Equal(t, "foo-v1.0.0-linux-amd64.tar.gz.sha256", ex.Assets[0].Name)
+		assert.True(t, ex.Assets[0].Selected)
+		assert.False(t, ex.Assets[0].Rejected)
+	})
+
+	t.Run("nothing selected", func(t *testing.T) {
+		ex, err := ExplainAssets(newSpec(&CommonSpec{}), "windows", "amd64")
+		require.NoError(t, err)
+		for _, d := range ex.Assets {
+			assert.False(t, d.Selected)
+			assert.True(t, d.Rejected)
+		}
+	})
+
+	t.Run("not a release", func(t *testing.T) {
+		spec := &LocalSpec{CommonSpec: &CommonSpec{From: "local"}, Path: "/opt/foo"}
+		_, err := ExplainAssets(spec, "linux", "amd64")
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,155 @@
+package gpkg
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// GiteaRelease is a source resolving packages from releases of a repository
+// hosted on a Gitea or Forgejo instance.
+type GiteaRelease struct {
+	releaseSource
+	baseURL string
+	owner   string
+	repo    string
+	token   string
+	client  *http.Client
+}
+
+type giteaRelease struct {
+	TagName     string              `json:"tag_name"`
+	Draft       bool                `json:"draft"`
+	Prerelease  bool                `json:"prerelease"`
+	PublishedAt time.Time           `json:"published_at"`
+	Assets      []giteaReleaseAsset `json:"assets"`
+}
+
+type giteaReleaseAsset struct {
+	Name               string `json:"name"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+}
+
+func NewGiteaRelease(host, name, ref, token string, client *http.Client) (*GiteaRelease, error) {
+	if host == "" {
+		return nil, fmt.Errorf("host is required")
+	}
+	parts := strings.Split(name, "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("failed to get owner and repo")
+	}
+	if client == nil {
+		client = credentialClient
+	}
+
+	gr := &GiteaRelease{
+		baseURL: giteaBaseURL(host),
+		owner:   parts[0],
+		repo:    parts[1],
+		token:   token,
+		client:  client,
+	}
+	gr.releaseSource = newReleaseSource(ref, gr.fetchAssets, gr.headerFor)
+	return gr, nil
+}
+
+// giteaBaseURL returns the URL of the instance, assuming https when host has
+// no scheme.
+func giteaBaseURL(host string) string {
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	return strings.TrimSuffix(host, "/")
+}
+
+func (gr *GiteaRelease) header() http.Header {
+	h := http.Header{}
+	if gr.token != "" {
+		h.Set("Authorization", "token "+gr.token)
+	}
+	return h
+}
+
+// headerFor returns the headers to fetch rawURL with. The token is only sent
+// to the Gitea instance, as release assets may link to any host.
+func (gr *GiteaRelease) headerFor(rawURL string) http.Header {
+	return headerForHost(gr.header(), rawURL, gr.baseURL)
+}
+
+func (gr *GiteaRelease) releasesURL() string {
+	return fmt.Sprintf("%s/api/v1/repos/%s/%s/releases", gr.baseURL, url.PathEscape(gr.owner), url.PathEscape(gr.repo))
+}
+
+// fetchRelease returns the release ref resolves to, from the endpoint of the
+// latest release or of the tag, or by listing releases for a version
+// constraint and newest.
+func (gr *GiteaRelease) fetchRelease() (*giteaRelease, error) {
+	u := gr.releasesURL()
+	switch {
+	case listsReleases(gr.ref, gr.prerelease):
+		rels, err := gr.listReleases()
+		if err != nil {
+			return nil, err
+		}
+		summaries := make([]releaseSummary, len(rels))
+		for i, rel := range rels {
+			summaries[i] = releaseSummary{
+				tag:        rel.TagName,
+				published:  rel.PublishedAt,
+				draft:      rel.Draft,
+				prerelease: rel.Prerelease,
+			}
+		}
+		i, err := pickRelease(gr.ref, gr.prerelease, summaries)
+		if err != nil {
+			return nil, fmt.Errorf("%s. repo=%s/%s", err, gr.owner, gr.repo)
+		}
+		return rels[i], nil
+	case gr.ref == "latest" || gr.ref == "":
+		u += "/latest"
+	default:
+		u += "/tags/" + url.PathEscape(gr.ref)
+	}
+
+	rel := &giteaRelease{}
+	if err := getJSON(gr.client, u, gr.header(), rel); err != nil {
+		return nil, err
+	}
+	return rel, nil
+}
+
+// listReleases returns all the releases of the repository. The page size is
+// capped by the instance, so pages are fetched until an empty one.
+func (gr *GiteaRelease) listReleases() ([]*giteaRelease, error) {
+	var rels []*giteaRelease
+	for page := 1; ; page++ {
+		var batch []*giteaRelease
+		u := fmt.Sprintf("%s?limit=50&page=%d", gr.releasesURL(), page)
+		if err := getJSON(gr.client, u, gr.header(), &batch); err != nil {
+			return nil, err
+		}
+		if len(batch) == 0 {
+			return rels, nil
+		}
+		rels = append(rels, batch...)
+	}
+}
+
+// fetchAssets fetches the release and returns its tag and attachments.
+func (gr *GiteaRelease) fetchAssets() (string, []releaseAsset, error) {
+	rel, err := gr.fetchRelease()
+	if err != nil {
+		return "", nil, err
+	}
+
+	assets := make([]releaseAsset, 0, len(rel.Assets))
+	for _, a := range rel.Assets {
+		assets = append(assets, releaseAsset{
+			name: a.Name,
+			url:  a.BrowserDownloadURL,
+		})
+	}
+	return rel.TagName, assets, nil
+}
@@ -0,0 +1,181 @@
+package gpkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newGiteaTestServer starts a stand-in of the Gitea releases API serving the
+// given releases of repo, newest first.
+func newGiteaTestServer(t *testing.T, repo, token string, releases []*giteaRelease) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && r.Header.Get("Authorization") != "token "+token {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/assets/") {
+			w.Write([]byte(strings.TrimPrefix(r.URL.Path, "/assets/")))
+			return
+		}
+
+		prefix := fmt.Sprintf("/api/v1/repos/%s/releases", repo)
+		switch {
+		case r.URL.Path == prefix:
+			if r.URL.Query().Get("page") != "1" {
+				w.Write([]byte("[]"))
+				return
+			}
+			json.NewEncoder(w).Encode(releases)
+		case r.URL.Path == prefix+"/latest":
+			for _, rel := range releases {
+				if !rel.Draft && !rel.Prerelease {
+					json.NewEncoder(w).Encode(rel)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+		case strings.HasPrefix(r.URL.Path, prefix+"/tags/"):
+			tag := strings.TrimPrefix(r.URL.Path, prefix+"/tags/")
+			for _, rel := range releases {
+				if rel.TagName == tag {
+					json.NewEncoder(w).Encode(rel)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	for _, rel := range releases {
+		for i, a := range rel.Assets {
+			rel.Assets[i].BrowserDownloadURL = fmt.Sprintf("%s/assets/%s", srv.URL, a.Name)
+		}
+	}
+	return srv
+}
+
+func newGiteaTestRelease(tag string, assetNames ...string) *giteaRelease {
+	rel := &giteaRelease{TagName: tag}
+	for _, name := range assetNames {
+		rel.Assets = append(rel.Assets, giteaReleaseAsset{Name: name})
+	}
+	return rel
+}
+
+func TestNewGiteaRelease(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		repo     string
+		expected string
+		recvErr  bool
+	}{
+		{"host without scheme", "codeberg.org", "foo/bar", "https://codeberg.org", false},
+		{"host with scheme", "http://git.example.com/", "foo/bar", "http://git.example.com", false},
+		{"empty host", "", "foo/bar", "", true},
+		{"wrong format of repo", "codeberg.org", "foo", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gr, err := NewGiteaRelease(tt.host, tt.repo, "latest", "", nil)
+			if tt.recvErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, gr.baseURL)
+		})
+	}
+}
+
+func TestGiteaRelease_GetDownloader(t *testing.T) {
+	tests := []struct {
+		name     string
+		ref      string
+		token    string
+		expected string
+		recvErr  bool
+	}{
+		{"latest", "latest", "", "foo-v2.0.0-x86_64-linux", false},
+		{"tag", "v1.0.0", "", "foo-v1.0.0-x86_64-linux", false},
+		{"private repository", "latest", "secret", "foo-v2.0.0-x86_64-linux", false},
+		{"tag not found", "v9.9.9", "", "", true},
+		{"no compatible asset exists", "v0.1.0", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newGiteaTestServer(t, "foo/bar", tt.token, []*giteaRelease{
+				newGiteaTestRelease("v2.0.0", "foo-v2.0.0-x86_64-linux", "foo-v2.0.0-x86_64-darwin"),
+				newGiteaTestRelease("v1.0.0", "foo-v1.0.0-x86_64-linux"),
+				newGiteaTestRelease("v0.1.0", "foo"),
+			})
+
+			gr, err := NewGiteaRelease(srv.URL, "foo/bar", tt.ref, tt.token, nil)
+			require.NoError(t, err)
+			dl, err := gr.GetDownloader()
+			if tt.recvErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer dl.Close()
+			assert.Equal(t, tt.expected, dl.GetAssetName())
+		})
+	}
+}
+
+func TestGiteaRelease_ShouldUpdate(t *testing.T) {
+	prerelease := newGiteaTestRelease("v2.1.0")
+	prerelease.Prerelease = true
+	prerelease.PublishedAt = time.Date(2023, 6, 4, 0, 0, 0, 0, time.UTC)
+	// A backport published after v2.0.0
+	backport := newGiteaTestRelease("v1.0.1")
+	backport.PublishedAt = time.Date(2023, 6, 3, 0, 0, 0, 0, time.UTC)
+	srv := newGiteaTestServer(t, "foo/bar", "", []*giteaRelease{
+		prerelease,
+		newGiteaTestRelease("v2.0.0"),
+		backport,
+		newGiteaTestRelease("v1.0.0"),
+	})
+	tests := []struct {
+		name       string
+		ref        string
+		currentRef string
+		prerelease bool
+		expected   bool
+		nextRef    string
+	}{
+		{"new package", "latest", "", false, true, "v2.0.0"},
+		{"latest is newer", "latest", "v1.0.0", false, true, "v2.0.0"},
+		{"up to date", "", "v2.0.0", false, false, "v2.0.0"},
+		{"pinned", "v1.0.0", "v1.0.0", false, false, "v1.0.0"},
+		{"constraint", "~1.0", "v1.0.0", false, true, "v1.0.1"},
+		{"constraint skipping prereleases", "^2", "v1.0.0", false, true, "v2.0.0"},
+		{"constraint with prereleases", "^2", "v2.0.0", true, true, "v2.1.0"},
+		{"latest with prereleases", "latest", "v2.0.0", true, true, "v2.1.0"},
+		{"newest", "newest", "v2.0.0", false, true, "v1.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gr, err := NewGiteaRelease(srv.URL, "foo/bar", tt.ref, "", nil)
+			require.NoError(t, err)
+			gr.prerelease = tt.prerelease
+			yes, next, err := gr.ShouldUpdate(tt.currentRef)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, yes)
+			assert.Equal(t, tt.nextRef, next)
+		})
+	}
+}
@@ -3,8 +3,6 @@ package gpkg
 import (
 	"context"
 	"fmt"
-	"regexp"
-	"runtime"
 	"strings"
 
 	"github.com/google/go-github/v53/github"
@@ -12,15 +10,16 @@ import (
 )
 
 type GitHubRelease struct {
+	releaseSource
 	owner  string
 	repo   string
-	ref    string
 	client releaseGetter
 }
 
 type releaseGetter interface {
 	GetLatestRelease(context.Context, string, string) (*github.RepositoryRelease, *github.Response, error)
 	GetReleaseByTag(context.Context, string, string, string) (*github.RepositoryRelease, *github.Response, error)
+	ListReleases(context.Context, string, string, *github.ListOptions) ([]*github.RepositoryRelease, *github.Response, error)
 }
 
 var _ releaseGetter = &github.RepositoriesService{}
@@ -37,78 +36,79 @@ func NewGitHubRelease(name, ref string, client releaseGetter) (*GitHubRelease, e
 		client = github.NewClient(httpcache.NewMemoryCacheTransport().Client()).Repositories
 	}
 
-	return &GitHubRelease{
+	ghr := &GitHubRelease{
 		owner:  owner,
 		repo:   repo,
-		ref:    ref,
 		client: client,
-	}, nil
-}
-
-func (ghr *GitHubRelease) GetDownloader() (Downloader, error) {
-	var err error
-	var rr *github.RepositoryRelease
-	if ghr.ref == "latest" || ghr.ref == "" {
-		rr, _, err = ghr.client.GetLatestRelease(context.Background(), ghr.owner, ghr.repo)
-	} else {
-		rr, _, err = ghr.client.GetReleaseByTag(context.Background(), ghr.owner, ghr.repo, ghr.ref)
-	}
-	if err != nil {
-		return nil, err
 	}
+	ghr.releaseSource = newReleaseSource(ref, ghr.fetchAssets, nil)
+	return ghr, nil
+}
 
-	var name, url string
-	for _, a := range rr.Assets {
-		if isCompatibleAssetForMachine(runtime.GOOS, runtime.GOARCH, a.GetName()) {
-			name = a.GetName()
-			url = a.GetBrowserDownloadURL()
-			break
+// fetchRelease returns the release ref resolves to. A version constraint
+// resolves to the highest release satisfying it, and newest to the release
+// published last.
+func (ghr *GitHubRelease) fetchRelease() (*github.RepositoryRelease, error) {
+	ctx := context.Background()
+	switch {
+	case listsReleases(ghr.ref, ghr.prerelease):
+		rrs, err := ghr.listReleases()
+		if err != nil {
+			return nil, err
 		}
+		summaries := make([]releaseSummary, len(rrs))
+		for i, rr := range rrs {
+			summaries[i] = releaseSummary{
+				tag:        rr.GetTagName(),
+				published:  rr.GetPublishedAt().Time,
+				draft:      rr.GetDraft(),
+				prerelease: rr.GetPrerelease(),
+			}
+		}
+		i, err := pickRelease(ghr.ref, ghr.prerelease, summaries)
+		if err != nil {
+			return nil, fmt.Errorf("%s. repo=%s/%s", err, ghr.owner, ghr.repo)
+		}
+		return rrs[i], nil
+	case ghr.ref == "latest" || ghr.ref == "":
+		rr, _, err := ghr.client.GetLatestRelease(ctx, ghr.owner, ghr.repo)
+		return rr, err
 	}
-	if name == "" {
-		return nil, fmt.Errorf("No compatible asset found. ref=%s", ghr.ref)
-	}
-
-	dl, err := NewHTTPDownloader(name, url)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to create a downloader. err=%s", err)
-	}
-	return dl, nil
+	rr, _, err := ghr.client.GetReleaseByTag(ctx, ghr.owner, ghr.repo, ghr.ref)
+	return rr, err
 }
 
-func (ghr *GitHubRelease) ShouldUpdate(currentRef string) (bool, string, error) {
-	if ghr.ref == "latest" || ghr.ref == "" {
-		rr, _, err := ghr.client.GetLatestRelease(context.Background(), ghr.owner, ghr.repo)
+// listReleases returns all the releases of the repository.
+func (ghr *GitHubRelease) listReleases() ([]*github.RepositoryRelease, error) {
+	var rrs []*github.RepositoryRelease
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := ghr.client.ListReleases(context.Background(), ghr.owner, ghr.repo, opts)
 		if err != nil {
-			return false, "", err
+			return nil, err
+		}
+		rrs = append(rrs, page...)
+		if resp == nil || resp.NextPage == 0 {
+			return rrs, nil
 		}
-		return rr.GetTagName() != currentRef, rr.GetTagName(), nil
+		opts.Page = resp.NextPage
 	}
-	return ghr.ref != currentRef, ghr.ref, nil
 }
 
-var (
-	osDarwinRe  = regexp.MustCompile(`(?i)(darwin|macos)`)
-	archAmd64Re = regexp.MustCompile(`(?i)(amd64|x86_64)`)
-)
-
-func isCompatibleAssetForMachine(os, arch, assetName string) bool {
-	hasOS := false
-	switch os {
-	case "darwin":
-		hasOS = osDarwinRe.MatchString(assetName)
-	default:
-		hasOS = strings.Contains(assetName, os)
+// fetchAssets fetches the release and returns its tag and the download URLs
+// of its assets.
+func (ghr *GitHubRelease) fetchAssets() (string, []releaseAsset, error) {
+	rr, err := ghr.fetchRelease()
+	if err != nil {
+		return "", nil, err
 	}
 
-	hasArch := false
-	switch arch {
-	case "amd64":
-		hasArch = archAmd64Re.MatchString(assetName)
-	case "arm":
-		hasArch = strings.Contains(assetName, "arm") && !strings.Contains(assetName, "arm64")
-	default:
-		hasArch = strings.Contains(assetName, arch)
+	assets := make([]releaseAsset, 0, len(rr.Assets))
+	for _, a := range rr.Assets {
+		assets = append(assets, releaseAsset{
+			name: a.GetName(),
+			url:  a.GetBrowserDownloadURL(),
+		})
 	}
-	return hasOS && hasArch
+	return rr.GetTagName(), assets, nil
 }
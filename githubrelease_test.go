@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-github/v53/github"
 	"github.com/stretchr/testify/assert"
@@ -25,7 +27,7 @@ func TestNewGitHubRelease(t *testing.T) {
 		{
 			"valid",
 			input{"foo/bar", "latest"},
-			&GitHubRelease{"foo", "bar", "latest", nil},
+			&GitHubRelease{owner: "foo", repo: "bar"},
 			false,
 		},
 		{
@@ -42,7 +44,8 @@ func TestNewGitHubRelease(t *testing.T) {
 				require.Error(t, err)
 			} else {
 				require.NoError(t, err)
-				checkDiff(t, GitHubRelease{}, tt.expected, got, "client")
+				checkDiff(t, GitHubRelease{}, tt.expected, got, "client", "releaseSource")
+				assert.Equal(t, tt.input.ref, got.ref)
 			}
 		})
 	}
@@ -51,7 +54,11 @@ func TestNewGitHubRelease(t *testing.T) {
 type mockRepositoriesService struct {
 	servers []*httptest.Server
 	data    *github.RepositoryRelease
-	err     error
+	// others are the releases listed along with data.
+	others []*github.RepositoryRelease
+	err    error
+	// listed counts calls to ListReleases.
+	listed int
 }
 
 var _ releaseGetter = &mockRepositoriesService{}
@@ -70,11 +77,20 @@ func (s *mockRepositoriesService) GetReleaseByTag(ctx context.Context, owner, re
 	return s.data, nil, nil
 }
 
+func (s *mockRepositoriesService) ListReleases(ctx context.Context, owner, repo string, opts *github.ListOptions) ([]*github.RepositoryRelease, *github.Response, error) {
+	s.listed++
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+	return append([]*github.RepositoryRelease{s.data}, s.others...), nil, nil
+}
+
 func newMockRepositoriesService(tag string, assetNames []string) *mockRepositoriesService {
 	svc := &mockRepositoriesService{}
 	rel := &github.RepositoryRelease{}
 	rel.TagName = &tag
 	for _, name := range assetNames {
+		name := name
 		srv := newTestServer("/"+name, 200, name)
 		svc.servers = append(svc.servers, srv)
 		u := fmt.Sprintf("%s/%s", srv.URL, name)
@@ -157,13 +173,138 @@ func TestGitHubRelease_GetDownloader(t *testing.T) {
 				require.Error(t, err)
 			} else {
 				require.NoError(t, err)
-				checkDiff(t, HTTPDownloader{}, tt.expected, got, "ReadCloser")
+				// The URL depends on the port of the test server.
+				checkDiff(t, HTTPDownloader{}, tt.expected, got, "ReadCloser", "url")
+				assert.True(t, strings.HasSuffix(got.(*HTTPDownloader).url, "/"+tt.expected.name))
+			}
+		})
+	}
+}
+
+func TestGitHubRelease_GetDownloader_AssetRules(t *testing.T) {
+	svc := newMockRepositoriesService("v1.0.0", []string{
+		"foo-v1.0.0-x86_64-linux-musl.tar.gz",
+		"foo-v1.0.0-x86_64-linux.tar.gz",
+		"foo-v1.0.0-x86_64-linux.deb",
+	})
+	defer svc.Close()
+
+	ghr, err := NewGitHubRelease("foo/bar", "v1.0.0", svc)
+	require.NoError(t, err)
+	ghr.rules = &assetRules{include: "*.tar.gz", exclude: []string{"*musl*"}}
+	dl, err := ghr.GetDownloader()
+	require.NoError(t, err)
+	defer dl.Close()
+	assert.Equal(t, "foo-v1.0.0-x86_64-linux.tar.gz", dl.GetAssetName())
+}
+
+func TestGitHubRelease_VersionConstraint(t *testing.T) {
+	svc := newMockRepositoriesService("v1.4.2", []string{"foo-v1.4.2-x86_64-linux"})
+	defer svc.Close()
+	prerelease := true
+	svc.others = []*github.RepositoryRelease{
+		{TagName: github.String("v2.0.0")},
+		{TagName: github.String("v1.5.0")},
+		{TagName: github.String("v1.4.1")},
+		{TagName: github.String("v1.4.3-rc.1")},
+		{TagName: github.String("v1.4.9"), Prerelease: &prerelease},
+		{TagName: github.String("nightly")},
+	}
+
+	tests := []struct {
+		ref      string
+		expected string
+		recvErr  bool
+	}{
+		{"~1.4", "v1.4.2", false},
+		{"^1", "v1.5.0", false},
+		{">=1.4, <1.5", "v1.4.2", false},
+		{"1.4.x", "v1.4.2", false},
+		{"1.x", "v1.5.0", false},
+		{"^2", "v2.0.0", false},
+		{"^3", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			ghr, err := NewGitHubRelease("foo/bar", tt.ref, svc)
+			require.NoError(t, err)
+			yes, next, err := ghr.ShouldUpdate("v1.4.1")
+			if tt.recvErr {
+				require.Error(t, err)
+				return
 			}
+			require.NoError(t, err)
+			assert.True(t, yes)
+			assert.Equal(t, tt.expected, next)
 		})
 	}
+
+	t.Run("download", func(t *testing.T) {
+		ghr, err := NewGitHubRelease("foo/bar", "~1.4", svc)
+		require.NoError(t, err)
+		dl, err := ghr.GetDownloader()
+		require.NoError(t, err)
+		defer dl.Close()
+		assert.Equal(t, "foo-v1.4.2-x86_64-linux", dl.GetAssetName())
+	})
+}
+
+func TestGitHubRelease_ResolvedOnce(t *testing.T) {
+	svc := newMockRepositoriesService("v1.4.2", []string{"foo-v1.4.2-x86_64-linux"})
+	defer svc.Close()
+
+	ghr, err := NewGitHubRelease("foo/bar", "~1.4", svc)
+	require.NoError(t, err)
+	_, next, err := ghr.ShouldUpdate("")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.4.2", next)
+
+	// Published after the update was checked
+	svc.others = append(svc.others, &github.RepositoryRelease{TagName: github.String("v1.4.3")})
+	dl, err := ghr.GetDownloader()
+	require.NoError(t, err)
+	defer dl.Close()
+	assert.Equal(t, "foo-v1.4.2-x86_64-linux", dl.GetAssetName())
+	assert.Equal(t, 1, svc.listed)
 }
 
-func TestIsCompatibleAssetForMachine(t *testing.T) {
+func TestGitHubRelease_Prerelease(t *testing.T) {
+	svc := newMockRepositoriesService("v1.4.2", []string{"foo-v1.4.2-x86_64-linux"})
+	defer svc.Close()
+	prerelease := true
+	svc.data.PublishedAt = &github.Timestamp{Time: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)}
+	svc.others = []*github.RepositoryRelease{
+		{
+			TagName:     github.String("v1.5.0-rc.1"),
+			Prerelease:  &prerelease,
+			PublishedAt: &github.Timestamp{Time: time.Date(2023, 6, 2, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	tests := []struct {
+		ref        string
+		prerelease bool
+		expected   string
+	}{
+		{"newest", false, "v1.4.2"},
+		{"newest", true, "v1.5.0-rc.1"},
+		{"latest", true, "v1.5.0-rc.1"},
+		{"~1.4", true, "v1.4.2"},
+		{"^1", true, "v1.5.0-rc.1"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s prerelease=%t", tt.ref, tt.prerelease), func(t *testing.T) {
+			ghr, err := NewGitHubRelease("foo/bar", tt.ref, svc)
+			require.NoError(t, err)
+			ghr.prerelease = tt.prerelease
+			_, next, err := ghr.ShouldUpdate("")
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, next)
+		})
+	}
+}
+
+func TestAssetRules_Find_Machine(t *testing.T) {
 	osList := []struct {
 		value string
 		ids   []string
@@ -271,18 +412,14 @@ func TestIsCompatibleAssetForMachine(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			var found string
-			for _, file := range tt.files {
-				if isCompatibleAssetForMachine(tt.goOS, tt.goArch, file) {
-					found = file
-					break
-				}
-			}
-			if found != "" {
-				assert.Equal(t, tt.expectedFile, found)
-			} else {
-				t.Errorf("No compatible asset found: %s", tt.expectedFile)
+			assets := make([]releaseAsset, len(tt.files))
+			for i, file := range tt.files {
+				assets[i] = releaseAsset{name: file}
 			}
+			var rules *assetRules
+			found, ok := rules.find(platform{os: tt.goOS, arch: tt.goArch}, assets)
+			require.True(t, ok, "No compatible asset found: %s", tt.expectedFile)
+			assert.Equal(t, tt.expectedFile, found.name)
 		})
 	}
 }
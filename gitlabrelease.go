@@ -0,0 +1,172 @@
+package gpkg
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultGitLabBaseURL = "https://gitlab.com"
+
+// GitLabRelease is a source resolving packages from releases of a project
+// hosted on gitlab.com or a self-hosted GitLab instance.
+type GitLabRelease struct {
+	releaseSource
+	baseURL string
+	project string
+	token   string
+	client  *http.Client
+}
+
+type gitlabRelease struct {
+	TagName         string    `json:"tag_name"`
+	ReleasedAt      time.Time `json:"released_at"`
+	UpcomingRelease bool      `json:"upcoming_release"`
+	Assets          struct {
+		Links []gitlabReleaseLink `json:"links"`
+	} `json:"assets"`
+}
+
+type gitlabReleaseLink struct {
+	Name           string `json:"name"`
+	URL            string `json:"url"`
+	DirectAssetURL string `json:"direct_asset_url"`
+}
+
+func NewGitLabRelease(baseURL, project, ref, token string, client *http.Client) (*GitLabRelease, error) {
+	if project == "" || !strings.Contains(project, "/") {
+		return nil, fmt.Errorf("failed to get the project path. project=%q", project)
+	}
+	if baseURL == "" {
+		baseURL = defaultGitLabBaseURL
+	}
+	if client == nil {
+		client = credentialClient
+	}
+
+	glr := &GitLabRelease{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		project: project,
+		token:   token,
+		client:  client,
+	}
+	glr.releaseSource = newReleaseSource(ref, glr.fetchAssets, glr.headerFor)
+	return glr, nil
+}
+
+func (glr *GitLabRelease) header() http.Header {
+	h := http.Header{}
+	if glr.token != "" {
+		h.Set("PRIVATE-TOKEN", glr.token)
+	}
+	return h
+}
+
+// headerFor returns the headers to fetch rawURL with. The token is only sent
+// to the GitLab instance, as release assets may link to any host.
+func (glr *GitLabRelease) headerFor(rawURL string) http.Header {
+	return headerForHost(glr.header(), rawURL, glr.baseURL)
+}
+
+func (glr *GitLabRelease) releasesURL() string {
+	return fmt.Sprintf("%s/api/v4/projects/%s/releases", glr.baseURL, url.PathEscape(glr.project))
+}
+
+// fetchRelease returns the release ref resolves to. GitLab has no notion of
+// prereleases, so they can only be told by their versions.
+func (glr *GitLabRelease) fetchRelease() (*gitlabRelease, error) {
+	switch {
+	case listsReleases(glr.ref, glr.prerelease):
+		rels, err := glr.listReleases()
+		if err != nil {
+			return nil, err
+		}
+		// Upcoming releases are not released yet.
+		summaries := make([]releaseSummary, len(rels))
+		for i, rel := range rels {
+			summaries[i] = releaseSummary{
+				tag:        rel.TagName,
+				published:  rel.ReleasedAt,
+				draft:      rel.UpcomingRelease,
+				prerelease: isPrereleaseTag(rel.TagName),
+			}
+		}
+		i, err := pickRelease(glr.ref, glr.prerelease, summaries)
+		if err != nil {
+			return nil, fmt.Errorf("%s. project=%s", err, glr.project)
+		}
+		return rels[i], nil
+	case glr.ref == "latest" || glr.ref == "":
+		return glr.latestRelease()
+	}
+
+	rel := &gitlabRelease{}
+	u := fmt.Sprintf("%s/%s", glr.releasesURL(), url.PathEscape(glr.ref))
+	if err := getJSON(glr.client, u, glr.header(), rel); err != nil {
+		return nil, err
+	}
+	return rel, nil
+}
+
+// latestRelease returns the release released last, which is not a
+// prerelease. An upcoming release has a release date in the future, so it
+// comes first and is skipped.
+func (glr *GitLabRelease) latestRelease() (*gitlabRelease, error) {
+	const perPage = 20
+	for page := 1; ; page++ {
+		var batch []*gitlabRelease
+		u := fmt.Sprintf("%s?order_by=released_at&sort=desc&per_page=%d&page=%d", glr.releasesURL(), perPage, page)
+		if err := getJSON(glr.client, u, glr.header(), &batch); err != nil {
+			return nil, err
+		}
+		for _, rel := range batch {
+			if !rel.UpcomingRelease && !isPrereleaseTag(rel.TagName) {
+				return rel, nil
+			}
+		}
+		if len(batch) < perPage {
+			return nil, fmt.Errorf("No release found. project=%s", glr.project)
+		}
+	}
+}
+
+// listReleases returns all the releases of the project.
+func (glr *GitLabRelease) listReleases() ([]*gitlabRelease, error) {
+	const perPage = 100
+	var rels []*gitlabRelease
+	for page := 1; ; page++ {
+		var batch []*gitlabRelease
+		u := fmt.Sprintf("%s?per_page=%d&page=%d", glr.releasesURL(), perPage, page)
+		if err := getJSON(glr.client, u, glr.header(), &batch); err != nil {
+			return nil, err
+		}
+		rels = append(rels, batch...)
+		if len(batch) < perPage {
+			return rels, nil
+		}
+	}
+}
+
+// fetchAssets fetches the release and returns its tag and the links attached
+// to it, through the permanent URLs GitLab serves them at when there are any.
+func (glr *GitLabRelease) fetchAssets() (string, []releaseAsset, error) {
+	rel, err := glr.fetchRelease()
+	if err != nil {
+		return "", nil, err
+	}
+
+	assets := make([]releaseAsset, 0, len(rel.Assets.Links))
+	for _, l := range rel.Assets.Links {
+		u := l.DirectAssetURL
+		if u == "" {
+			u = l.URL
+		}
+		assets = append(assets, releaseAsset{
+			name: l.Name,
+			url:  u,
+		})
+	}
+	return rel.TagName, assets, nil
+}
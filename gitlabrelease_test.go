@@ -0,0 +1,206 @@
+package gpkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newGitLabTestServer starts a stand-in of the GitLab releases API serving the
+// given releases of project, newest first. Assets are served by the same server
+// and require the token when it is not empty.
+func newGitLabTestServer(t *testing.T, project, token string, releases []*gitlabRelease) *httptest.Server {
+	authorized := func(r *http.Request) bool {
+		return token == "" || r.Header.Get("PRIVATE-TOKEN") == token
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/assets/") {
+			w.Write([]byte(strings.TrimPrefix(r.URL.Path, "/assets/")))
+			return
+		}
+
+		prefix := fmt.Sprintf("/api/v4/projects/%s/releases", strings.Replace(project, "/", "%2F", -1))
+		p := r.URL.EscapedPath()
+		switch {
+		case p == prefix:
+			json.NewEncoder(w).Encode(releases)
+		case strings.HasPrefix(p, prefix+"/"):
+			tag := strings.TrimPrefix(p, prefix+"/")
+			for _, rel := range releases {
+				if rel.TagName == tag {
+					json.NewEncoder(w).Encode(rel)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	// Point asset links at the server now that its URL is known.
+	for _, rel := range releases {
+		for i, l := range rel.Assets.Links {
+			rel.Assets.Links[i].URL = fmt.Sprintf("%s/assets/%s", srv.URL, l.Name)
+		}
+	}
+	return srv
+}
+
+func newGitLabTestRelease(tag string, assetNames ...string) *gitlabRelease {
+	rel := &gitlabRelease{TagName: tag}
+	for _, name := range assetNames {
+		rel.Assets.Links = append(rel.Assets.Links, gitlabReleaseLink{Name: name})
+	}
+	return rel
+}
+
+func TestNewGitLabRelease(t *testing.T) {
+	t.Run("default base url", func(t *testing.T) {
+		glr, err := NewGitLabRelease("", "foo/bar", "latest", "", nil)
+		require.NoError(t, err)
+		assert.Equal(t, defaultGitLabBaseURL, glr.baseURL)
+	})
+	t.Run("trailing slash is removed", func(t *testing.T) {
+		glr, err := NewGitLabRelease("https://gitlab.example.com/", "group/sub/bar", "latest", "", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "https://gitlab.example.com", glr.baseURL)
+		assert.Equal(t, "group/sub/bar", glr.project)
+	})
+	t.Run("wrong format of project", func(t *testing.T) {
+		_, err := NewGitLabRelease("", "foo", "latest", "", nil)
+		require.Error(t, err)
+	})
+}
+
+func TestGitLabRelease_GetDownloader(t *testing.T) {
+	tests := []struct {
+		name     string
+		ref      string
+		token    string
+		expected string
+		recvErr  bool
+	}{
+		{"latest", "latest", "", "foo-v2.0.0-x86_64-linux", false},
+		{"empty ref means latest", "", "", "foo-v2.0.0-x86_64-linux", false},
+		{"tag", "v1.0.0", "", "foo-v1.0.0-x86_64-linux", false},
+		{"private project", "latest", "secret", "foo-v2.0.0-x86_64-linux", false},
+		{"tag not found", "v9.9.9", "", "", true},
+		{"no compatible asset exists", "v0.1.0", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newGitLabTestServer(t, "group/foo", tt.token, []*gitlabRelease{
+				newGitLabTestRelease("v2.0.0", "foo-v2.0.0-x86_64-linux", "foo-v2.0.0-x86_64-darwin"),
+				newGitLabTestRelease("v1.0.0", "foo-v1.0.0-x86_64-linux"),
+				newGitLabTestRelease("v0.1.0", "foo"),
+			})
+
+			glr, err := NewGitLabRelease(srv.URL, "group/foo", tt.ref, tt.token, nil)
+			require.NoError(t, err)
+			dl, err := glr.GetDownloader()
+			if tt.recvErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer dl.Close()
+			assert.Equal(t, tt.expected, dl.GetAssetName())
+		})
+	}
+
+	t.Run("missing token", func(t *testing.T) {
+		srv := newGitLabTestServer(t, "group/foo", "secret", []*gitlabRelease{
+			newGitLabTestRelease("v1.0.0", "foo-v1.0.0-x86_64-linux"),
+		})
+		glr, err := NewGitLabRelease(srv.URL, "group/foo", "latest", "", nil)
+		require.NoError(t, err)
+		_, err = glr.GetDownloader()
+		require.Error(t, err)
+	})
+}
+
+func TestGitLabRelease_GetDownloader_OtherHost(t *testing.T) {
+	var leaked []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "" {
+			leaked = append(leaked, r.URL.Path)
+		}
+		fmt.Fprintf(w, "%s  foo-v1.0.0-x86_64-linux\n", strings.Repeat("ab", 32))
+	}))
+	t.Cleanup(other.Close)
+
+	rel := newGitLabTestRelease("v1.0.0", "foo-v1.0.0-x86_64-linux", "checksums.txt")
+	srv := newGitLabTestServer(t, "group/foo", "secret", []*gitlabRelease{rel})
+	// Links of a release may point anywhere.
+	rel.Assets.Links[0].DirectAssetURL = other.URL + "/foo"
+	rel.Assets.Links[1].DirectAssetURL = other.URL + "/checksums.txt"
+
+	glr, err := NewGitLabRelease(srv.URL, "group/foo", "v1.0.0", "secret", nil)
+	require.NoError(t, err)
+	dl, err := glr.GetDownloader()
+	require.NoError(t, err)
+	defer dl.Close()
+	assert.Equal(t, strings.Repeat("ab", 32), dl.(*HTTPDownloader).ExpectedSHA256())
+	assert.Empty(t, leaked)
+}
+
+func TestGitLabRelease_ShouldUpdate(t *testing.T) {
+	rels := []*gitlabRelease{
+		newGitLabTestRelease("v3.0.0"),
+		newGitLabTestRelease("v2.1.0-rc.1"),
+		newGitLabTestRelease("v2.0.0"),
+		newGitLabTestRelease("v1.0.1"),
+		newGitLabTestRelease("v1.0.0"),
+	}
+	// Sorted first by its release date in the future
+	rels[0].UpcomingRelease = true
+	for i, rel := range rels {
+		rel.ReleasedAt = time.Date(2024, 1, 10-i, 0, 0, 0, 0, time.UTC)
+	}
+	srv := newGitLabTestServer(t, "group/foo", "", rels)
+	tests := []struct {
+		name       string
+		ref        string
+		prerelease bool
+		currentRef string
+		expected   bool
+		nextRef    string
+	}{
+		{"new package", "latest", false, "", true, "v2.0.0"},
+		{"latest is newer", "latest", false, "v1.0.0", true, "v2.0.0"},
+		{"up to date", "latest", false, "v2.0.0", false, "v2.0.0"},
+		{"newest", "newest", false, "v2.0.0", false, "v2.0.0"},
+		{"latest prerelease", "latest", true, "v2.0.0", true, "v2.1.0-rc.1"},
+		{"newest prerelease", "newest", true, "v2.0.0", true, "v2.1.0-rc.1"},
+		{"pinned", "v1.0.0", false, "v1.0.0", false, "v1.0.0"},
+		{"pinned to another tag", "v1.0.0", false, "v2.0.0", true, "v1.0.0"},
+		{"constraint", "~1.0", false, "v1.0.0", true, "v1.0.1"},
+		{"constraint up to date", ">=1, <2", false, "v1.0.1", false, "v1.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			glr, err := NewGitLabRelease(srv.URL, "group/foo", tt.ref, "", nil)
+			require.NoError(t, err)
+			glr.prerelease = tt.prerelease
+			yes, next, err := glr.ShouldUpdate(tt.currentRef)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, yes)
+			assert.Equal(t, tt.nextRef, next)
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package gpkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+)
+
+// GitRepository is a source installing a snapshot of a git repository at a
+// branch, a tag or a commit. The repository is fetched into a bare clone kept
+// under dir so that updates only transfer new objects.
+type GitRepository struct {
+	url string
+	ref string
+	dir string
+
+	commit string
+}
+
+// gitCacheLocks serializes git operations on the same bare clone.
+var gitCacheLocks sync.Map
+
+func NewGitRepository(url, ref, dir string) (*GitRepository, error) {
+	if url == "" {
+		return nil, errors.New("url is required")
+	}
+	// git would read them as options.
+	if strings.HasPrefix(url, "-") {
+		return nil, fmt.Errorf("invalid url %q", url)
+	}
+	if strings.HasPrefix(ref, "-") {
+		return nil, fmt.Errorf("invalid ref %q", ref)
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("git is required to install from a git repository. err=%v", err)
+	}
+	return &GitRepository{
+		url: url,
+		ref: ref,
+		dir: dir,
+	}, nil
+}
+
+func (g *GitRepository) git(args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"--git-dir", g.dir}, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed. err=%v, stderr=%s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func (g *GitRepository) fetch() error {
+	if _, err := os.Stat(g.dir); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(g.dir, 0755); err != nil {
+			return err
+		}
+		if _, err := g.git("init", "--bare", "--quiet"); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	_, err := g.git(
+		"fetch", "--quiet", "--force", "--prune", "--end-of-options", g.url,
+		"+HEAD:refs/gpkg/HEAD",
+		"+refs/heads/*:refs/heads/*",
+		"+refs/tags/*:refs/tags/*",
+	)
+	return err
+}
+
+// resolve fetches the repository and returns the commit SHA that ref points to.
+func (g *GitRepository) resolve() (string, error) {
+	if g.commit != "" {
+		return g.commit, nil
+	}
+
+	mu, _ := gitCacheLocks.LoadOrStore(g.dir, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	if err := g.fetch(); err != nil {
+		return "", err
+	}
+
+	rev := g.ref
+	if rev == "" || rev == "latest" {
+		rev = "refs/gpkg/HEAD"
+	}
+	commit, err := g.git("rev-parse", "--verify", "--quiet", "--end-of-options", rev+"^{commit}")
+	if err != nil {
+		// A commit that no branch or tag contains has to be fetched explicitly.
+		if _, ferr := g.git("fetch", "--quiet", "--end-of-options", g.url, rev); ferr != nil {
+			return "", fmt.Errorf("ref not found. ref=%s", g.ref)
+		}
+		if commit, err = g.git("rev-parse", "--verify", "--quiet", "--end-of-options", rev+"^{commit}"); err != nil {
+			return "", fmt.Errorf("ref not found. ref=%s", g.ref)
+		}
+	}
+	g.commit = commit
+	return g.commit, nil
+}
+
+// resolveRemote returns the commit SHA that ref points to by listing the refs
+// of the remote, without fetching anything. A commit which is not the tip of
+// a branch or a tag can only be resolved if the cache already has it.
+func (g *GitRepository) resolveRemote() (string, error) {
+	if reGitCommit.MatchString(g.ref) {
+		return g.ref, nil
+	}
+
+	patterns := []string{g.ref, g.ref + "^{}"}
+	names := []string{"refs/tags/" + g.ref + "^{}", "refs/tags/" + g.ref, "refs/heads/" + g.ref, g.ref + "^{}", g.ref}
+	if g.ref == "" || g.ref == "latest" {
+		patterns = []string{"HEAD"}
+		names = []string{"HEAD"}
+	}
+	cmd := exec.Command("git", append([]string{"ls-remote", "--end-of-options", g.url}, patterns...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git ls-remote failed. err=%v, stderr=%s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	refs := map[string]string{}
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			refs[fields[1]] = fields[0]
+		}
+	}
+	for _, name := range names {
+		if commit, ok := refs[name]; ok {
+			return commit, nil
+		}
+	}
+
+	if _, err := os.Stat(g.dir); err == nil {
+		if commit, err := g.git("rev-parse", "--verify", "--quiet", "--end-of-options", g.ref+"^{commit}"); err == nil {
+			return commit, nil
+		}
+	}
+	return "", fmt.Errorf("ref not found. ref=%s", g.ref)
+}
+
+// checkUpdate is like ShouldUpdate but leaves the cache as it is.
+func (g *GitRepository) checkUpdate(currentRef string) (bool, string, error) {
+	commit, err := g.resolveRemote()
+	if err != nil {
+		return false, "", err
+	}
+	return commit != currentRef, commit, nil
+}
+
+func (g *GitRepository) GetDownloader() (Downloader, error) {
+	commit, err := g.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSuffix(path.Base(g.url), ".git")
+	name = fmt.Sprintf("%s-%s.tar", name, commit[:12])
+	return newStreamDownloader(name, func(w io.Writer) error {
+		cmd := exec.Command("git", "--git-dir", g.dir, "archive", "--format=tar", "--end-of-options", commit)
+		var stderr bytes.Buffer
+		cmd.Stdout = w
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("git archive failed. err=%v, stderr=%s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}), nil
+}
+
+func (g *GitRepository) ShouldUpdate(currentRef string) (bool, string, error) {
+	commit, err := g.resolve()
+	if err != nil {
+		return false, "", err
+	}
+	return commit != currentRef, commit, nil
+}
@@ -0,0 +1,200 @@
+package gpkg
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testGitRepository is a repository in a temporary directory used as a remote.
+type testGitRepository struct {
+	t   *testing.T
+	dir string
+}
+
+func newTestGitRepository(t *testing.T) *testGitRepository {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	r := &testGitRepository{t: t, dir: t.TempDir()}
+	r.git("init", "--quiet", "--initial-branch=main")
+	return r
+}
+
+func (r *testGitRepository) git(args ...string) string {
+	cmd := exec.Command("git", append([]string{"-C", r.dir}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=gpkg", "GIT_AUTHOR_EMAIL=gpkg@example.com",
+		"GIT_COMMITTER_NAME=gpkg", "GIT_COMMITTER_EMAIL=gpkg@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	require.NoError(r.t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+// commit writes files and commits them, returning the commit SHA.
+func (r *testGitRepository) commit(files ...string) string {
+	for _, f := range files {
+		p := filepath.Join(r.dir, f)
+		require.NoError(r.t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(r.t, os.WriteFile(p, []byte(f), 0644))
+	}
+	r.git("add", "-A")
+	r.git("commit", "--quiet", "--allow-empty", "-m", "commit")
+	return r.git("rev-parse", "HEAD")
+}
+
+func (r *testGitRepository) url() string {
+	return "file://" + r.dir
+}
+
+func TestGitRepository_ShouldUpdate(t *testing.T) {
+	remote := newTestGitRepository(t)
+	first := remote.commit("README")
+	remote.git("tag", "v1.0.0")
+	remote.git("checkout", "--quiet", "-b", "dev")
+	dev := remote.commit("dev.txt")
+	remote.git("checkout", "--quiet", "main")
+	head := remote.commit("bin/tool")
+
+	tests := []struct {
+		name       string
+		ref        string
+		currentRef string
+		expected   bool
+		nextRef    string
+		recvErr    bool
+	}{
+		{"default branch", "", "", true, head, false},
+		{"latest means default branch", "latest", head, false, head, false},
+		{"branch", "dev", first, true, dev, false},
+		{"tag", "v1.0.0", first, false, first, false},
+		{"commit", first, "", true, first, false},
+		{"ref not found", "unknown", "", false, "", true},
+	}
+	cacheDir := filepath.Join(t.TempDir(), "repo")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewGitRepository(remote.url(), tt.ref, cacheDir)
+			require.NoError(t, err)
+
+			yes, next, err := g.ShouldUpdate(tt.currentRef)
+			if tt.recvErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, yes)
+			assert.Equal(t, tt.nextRef, next)
+		})
+	}
+
+	t.Run("fetch new commits into the cache", func(t *testing.T) {
+		next := remote.commit("CHANGELOG")
+		g, err := NewGitRepository(remote.url(), "main", cacheDir)
+		require.NoError(t, err)
+
+		yes, got, err := g.ShouldUpdate(head)
+		require.NoError(t, err)
+		assert.True(t, yes)
+		assert.Equal(t, next, got)
+	})
+}
+
+func TestGitRepository_CheckUpdate(t *testing.T) {
+	remote := newTestGitRepository(t)
+	first := remote.commit("README")
+	remote.git("tag", "v1.0.0")
+	remote.git("tag", "-a", "v1.0.1", "-m", "v1.0.1")
+	remote.git("checkout", "--quiet", "-b", "dev")
+	dev := remote.commit("dev.txt")
+	remote.git("checkout", "--quiet", "main")
+	head := remote.commit("bin/tool")
+
+	tests := []struct {
+		name       string
+		ref        string
+		currentRef string
+		expected   bool
+		nextRef    string
+		recvErr    bool
+	}{
+		{"default branch", "", "", true, head, false},
+		{"latest means default branch", "latest", head, false, head, false},
+		{"branch", "dev", first, true, dev, false},
+		{"tag", "v1.0.0", first, false, first, false},
+		{"annotated tag", "v1.0.1", first, false, first, false},
+		{"full ref", "refs/heads/dev", "", true, dev, false},
+		{"commit", first, "", true, first, false},
+		{"abbreviated commit not in the cache", first[:12], "", false, "", true},
+		{"ref not found", "unknown", "", false, "", true},
+	}
+	cacheDir := filepath.Join(t.TempDir(), "repo")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewGitRepository(remote.url(), tt.ref, cacheDir)
+			require.NoError(t, err)
+
+			yes, next, err := g.checkUpdate(tt.currentRef)
+			if tt.recvErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, yes)
+				assert.Equal(t, tt.nextRef, next)
+			}
+			assert.NoDirExists(t, cacheDir)
+		})
+	}
+
+	t.Run("abbreviated commit in the cache", func(t *testing.T) {
+		g, err := NewGitRepository(remote.url(), first[:12], cacheDir)
+		require.NoError(t, err)
+		_, _, err = g.ShouldUpdate("")
+		require.NoError(t, err)
+
+		g, err = NewGitRepository(remote.url(), first[:12], cacheDir)
+		require.NoError(t, err)
+		_, next, err := g.checkUpdate("")
+		require.NoError(t, err)
+		assert.Equal(t, first, next)
+	})
+}
+
+func TestNewGitRepository_Options(t *testing.T) {
+	remote := newTestGitRepository(t)
+	remote.commit("README")
+	marker := filepath.Join(t.TempDir(), "marker")
+
+	_, err := NewGitRepository(remote.url(), "--upload-pack=touch "+marker+";git-upload-pack", filepath.Join(t.TempDir(), "repo"))
+	require.Error(t, err)
+	_, err = NewGitRepository("--upload-pack=touch "+marker, "", filepath.Join(t.TempDir(), "repo"))
+	require.Error(t, err)
+
+	// A ref given without the check is still not read as an option.
+	g := &GitRepository{url: remote.url(), ref: "--upload-pack=touch " + marker + ";git-upload-pack", dir: filepath.Join(t.TempDir(), "repo")}
+	_, _, err = g.ShouldUpdate("")
+	require.Error(t, err)
+	assert.NoFileExists(t, marker)
+}
+
+func TestGitRepository_GetDownloader(t *testing.T) {
+	remote := newTestGitRepository(t)
+	remote.commit("README", "bin/tool", "scripts/foo.sh")
+
+	g, err := NewGitRepository(remote.url(), "", filepath.Join(t.TempDir(), "repo"))
+	require.NoError(t, err)
+	dl, err := g.GetDownloader()
+	require.NoError(t, err)
+	defer dl.Close()
+	assert.True(t, strings.HasSuffix(dl.GetAssetName(), ".tar"))
+
+	dst := t.TempDir()
+	require.NoError(t, extract(dl, dst, dl.GetAssetName(), extractOptions{}))
+	assertDirectoryContents(t, dst, []string{"", "README", "bin", "bin/tool", "scripts", "scripts/foo.sh"})
+}
@@ -4,29 +4,80 @@ import (
 	"fmt"
 	"io"
 	"os"
-
-	cp "github.com/otiai10/copy"
+	"runtime"
+	"strings"
 )
 
+// ReconcilePackage installs or updates the package described by spec. Progress
+// is reported to ch, and the downloaded bytes are written to w as they are read.
 func ReconcilePackage(packagesDir string, states *StateData, spec PackageSpec, ch chan<- *Event, w io.Writer) error {
 	ev := newEventBuilder(spec)
 	ch <- ev.started()
+	if err := reconcilePackage(packagesDir, states, spec, nil, ev, ch, w); err != nil {
+		ch <- ev.failed(err)
+		return err
+	}
+	return nil
+}
+
+// ReconcileLockedPackage is like ReconcilePackage, but installs exactly the
+// artifact pinned in lock, and fails if the package is not locked or its
+// artifact has drifted from the lock.
+func ReconcileLockedPackage(packagesDir string, states *StateData, lock *LockData, spec PackageSpec, ch chan<- *Event, w io.Writer) error {
+	ev := newEventBuilder(spec)
+	ch <- ev.started()
+	err := func() error {
+		lp := lock.Find(spec)
+		if lp == nil {
+			if _, ok := spec.(*LocalSpec); ok {
+				// Local packages are never locked.
+				return reconcilePackage(packagesDir, states, spec, nil, ev, ch, w)
+			}
+			return fmt.Errorf("Not locked. Run gpkg lock to add it to the lock file")
+		}
+		if err := checkLockedRef(spec, lp); err != nil {
+			return err
+		}
+		return reconcilePackage(packagesDir, states, spec, lp, ev, ch, w)
+	}()
+	if err != nil {
+		ch <- ev.failed(err)
+		return err
+	}
+	return nil
+}
+
+func reconcilePackage(packagesDir string, states *StateData, spec PackageSpec, locked *LockedPackage, ev *EventBuilder, ch chan<- *Event, w io.Writer) error {
 	tmpDir, err := os.MkdirTemp("", "gpkg-*")
 	if err != nil {
 		return err
 	}
 	defer os.RemoveAll(tmpDir)
 
-	src, err := getSource(spec)
+	ref := spec.Common().Ref
+	var artifact *LockedArtifact
+	if locked != nil {
+		ref = locked.Ref
+		artifact = locked.Artifacts[HostLockPlatform()]
+	}
+	src, err := newSource(spec, ref, runtime.GOOS, runtime.GOARCH)
 	if err != nil {
 		return err
 	}
 
 	var currentRef string
-	if _, state, _ := states.FindState(spec); state != nil {
+	_, state, _ := states.FindState(spec)
+	if state != nil {
 		currentRef = state.Ref
 	}
 	yes, nextRef, err := src.ShouldUpdate(currentRef)
+	if err != nil {
+		return err
+	}
+	// The same ref may have been installed from another artifact.
+	if !yes && artifact != nil && !strings.EqualFold(state.SHA256, artifact.SHA256) {
+		yes = true
+	}
 	if !yes {
 		ch <- ev.skipped(currentRef)
 		return nil
@@ -38,36 +89,139 @@ func ReconcilePackage(packagesDir string, states *StateData, spec PackageSpec, c
 	}
 	defer dl.Close()
 
-	r := io.TeeReader(dl, w)
+	// A digest given in the spec takes precedence over the published one.
+	expected := spec.Common().SHA256
+	if cd, ok := dl.(checksumDownloader); ok && expected == "" {
+		expected = cd.ExpectedSHA256()
+	}
+	if locked != nil {
+		if err := checkLockedArtifact(spec, artifact, dl); err != nil {
+			return err
+		}
+		if artifact != nil {
+			expected = artifact.SHA256
+		}
+	}
+	if wn, ok := dl.(warner); ok && expected == "" && wn.Warning() != "" {
+		ch <- ev.warned(wn.Warning())
+	}
+	dr := newDigestReader(dl)
+	r := io.TeeReader(dr, w)
 
 	ch <- ev.downloadStarted(dl, currentRef, nextRef)
-	if err = extract(r, tmpDir, dl.GetAssetName()); err != nil {
+	if v := spec.Common().Verify; v != nil {
+		f, err := downloadVerified(v, dl, r)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		r = f
+	}
+	opts := extractOptions{
+		stripComponents: spec.Common().StripComponents,
+		stripTopDir:     spec.Common().StripTopDir,
+	}
+	if err = extract(r, tmpDir, dl.GetAssetName(), opts); err != nil {
 		return err
 	}
-	ch <- ev.downloadCompleted()
-
-	if err = cp.Copy(tmpDir, spec.PackagePath()); err != nil {
+	// Nothing has been installed yet since the asset is extracted to tmpDir.
+	if err = verifyChecksum(dl.GetAssetName(), expected, dr.Sum()); err != nil {
 		return err
 	}
+	ch <- ev.downloadCompleted()
 
 	ch <- ev.pickStarted()
 	if spec.Common().Pick != "" {
-		if err := Pick(spec.PackagePath(), spec.Common().Pick); err != nil {
+		if err := Pick(tmpDir, spec.Common().Pick); err != nil {
 			return err
 		}
 	}
 
-	states.Upsert(spec, nextRef)
+	// The previous version is kept for rollback.
+	if err = installVersion(spec.PackagePath(), nextRef, tmpDir); err != nil {
+		return err
+	}
+	states.Upsert(spec, nextRef, dr.Sum())
+	if err = pruneVersions(states, spec, spec.Common().config.GetKeepVersions()); err != nil {
+		return err
+	}
 
 	ch <- ev.completed()
 
 	return nil
 }
 
+// downloadVerified saves the asset read from r to a temporary file and verifies
+// its signature, which takes the whole asset, before anything is extracted.
+// The file is returned rewound.
+func downloadVerified(v *VerifySpec, dl Downloader, r io.Reader) (*os.File, error) {
+	f, err := os.CreateTemp("", "gpkg-*")
+	if err != nil {
+		return nil, err
+	}
+	err = func() error {
+		if _, err := io.Copy(f, r); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := verifySignature(v, dl, f); err != nil {
+			return err
+		}
+		_, err := f.Seek(0, io.SeekStart)
+		return err
+	}()
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
 func getSource(s PackageSpec) (Source, error) {
+	return newSource(s, s.Common().Ref, runtime.GOOS, runtime.GOARCH)
+}
+
+// newSource returns the source of s resolving ref, which replaces the one in
+// the spec, for goos and goarch.
+func newSource(s PackageSpec, ref, goos, goarch string) (Source, error) {
 	switch r := s.(type) {
 	case *GitHubReleaseSpec:
-		return NewGitHubRelease(r.Repo, r.Ref, nil)
+		ghr, err := NewGitHubRelease(r.Repo, ref, nil)
+		if err != nil {
+			return nil, err
+		}
+		ghr.rules = r.assetRules(goos, goarch)
+		ghr.prerelease = r.Prerelease
+		ghr.platform = newPlatform(goos, goarch)
+		return ghr, nil
+	case *GitLabReleaseSpec:
+		glr, err := NewGitLabRelease(r.BaseURL, r.Repo, ref, r.Token(), nil)
+		if err != nil {
+			return nil, err
+		}
+		glr.rules = r.assetRules(goos, goarch)
+		glr.prerelease = r.Prerelease
+		glr.platform = newPlatform(goos, goarch)
+		return glr, nil
+	case *GiteaReleaseSpec:
+		gr, err := NewGiteaRelease(r.Host, r.Repo, ref, r.Token(), nil)
+		if err != nil {
+			return nil, err
+		}
+		gr.rules = r.assetRules(goos, goarch)
+		gr.prerelease = r.Prerelease
+		gr.platform = newPlatform(goos, goarch)
+		return gr, nil
+	case *URLSpec:
+		return newURLSource(r, ref, goos, goarch, nil)
+	case *GitSpec:
+		return NewGitRepository(r.URL, ref, r.RepositoryCachePath())
+	case *LocalSpec:
+		return newLocalSource(r.resolvedPath(), r.Check), nil
 	default:
 		return nil, fmt.Errorf("Unknown spec detected. type=%T", r)
 	}
@@ -0,0 +1,104 @@
+package gpkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reconcileTestPackage runs ReconcilePackage with the events discarded.
+func reconcileTestPackage(states *StateData, spec PackageSpec) error {
+	ch := make(chan *Event)
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(done)
+	}()
+	err := ReconcilePackage(spec.Common().config.GetPackagesPath(), states, spec, ch, io.Discard)
+	close(ch)
+	<-done
+	return err
+}
+
+func TestReconcilePackage_Checksum(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "foo")
+	require.NoError(t, os.WriteFile(src, []byte("#!/bin/sh\n"), 0755))
+	sum := sha256.Sum256([]byte("#!/bin/sh\n"))
+	digest := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name    string
+		sha256  string
+		recvErr bool
+	}{
+		{"no checksum", "", false},
+		{"matched", digest, false},
+		{"mismatched", strings.Repeat("0", 64), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{CachePath: t.TempDir()}
+			spec := &LocalSpec{
+				CommonSpec: &CommonSpec{From: "local", Ref: "v1", SHA256: tt.sha256, config: cfg},
+				Path:       src,
+			}
+			states := &StateData{}
+
+			err := reconcileTestPackage(states, spec)
+			if tt.recvErr {
+				require.Error(t, err)
+				assert.NoDirExists(t, spec.PackagePath())
+				assert.Empty(t, states.States)
+				return
+			}
+			require.NoError(t, err)
+			assert.FileExists(t, filepath.Join(spec.PackagePath(), currentVersionName, "foo"))
+			_, state, err := states.FindState(spec)
+			require.NoError(t, err)
+			assert.Equal(t, digest, state.SHA256)
+		})
+	}
+}
+
+func TestReconcilePackage_ChecksumNotListed(t *testing.T) {
+	hostAsset := fmt.Sprintf("foo-v1.0.0-%s-%s", runtime.GOOS, runtime.GOARCH)
+	// The checksum file served holds its own name, which lists nothing.
+	srv := newGiteaTestServer(t, "owner/foo", "", []*giteaRelease{
+		newGiteaTestRelease("v1.0.0", hostAsset, "checksums.txt"),
+	})
+	spec := &GiteaReleaseSpec{
+		CommonSpec: &CommonSpec{From: "gitea", config: &Config{CachePath: t.TempDir()}},
+		Host:       srv.URL,
+		Repo:       "owner/foo",
+	}
+
+	ch := make(chan *Event)
+	var warnings []string
+	done := make(chan struct{})
+	go func() {
+		for ev := range ch {
+			if ev.Type == EventWarned {
+				warnings = append(warnings, ev.Data.(EventDataWarned).Message)
+			}
+		}
+		close(done)
+	}()
+	err := ReconcilePackage(spec.Common().config.GetPackagesPath(), &StateData{}, spec, ch, io.Discard)
+	close(ch)
+	<-done
+
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(spec.PackagePath(), currentVersionName, hostAsset))
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "checksums.txt")
+}
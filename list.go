@@ -0,0 +1,71 @@
+package gpkg
+
+import (
+	"time"
+)
+
+// Status of a package listed by ListPackages.
+const (
+	StatusInstalled    = "installed"
+	StatusNotInstalled = "not installed"
+	// StatusOrphaned is a package installed for a spec which is no longer in
+	// the config.
+	StatusOrphaned = "orphaned"
+)
+
+// PackageInfo describes a package in the config or installed.
+type PackageInfo struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	// Ref is the ref in the config, which is empty for an orphaned package.
+	Ref          string     `json:"ref"`
+	InstalledRef string     `json:"installed_ref,omitempty"`
+	Path         string     `json:"path,omitempty"`
+	InstalledAt  *time.Time `json:"installed_at,omitempty"`
+	Status       string     `json:"status"`
+}
+
+// ListPackages returns the packages in cfg in order, followed by the packages
+// installed for specs which are no longer in cfg.
+func ListPackages(cfg *Config, states *StateData) []*PackageInfo {
+	states.mu.Lock()
+	defer states.mu.Unlock()
+
+	installed := map[string]State{}
+	for _, st := range states.States {
+		installed[st.Spec.Unique()] = st
+	}
+
+	var pkgs []*PackageInfo
+	inConfig := map[string]bool{}
+	for _, spec := range cfg.Specs {
+		inConfig[spec.Unique()] = true
+		p := &PackageInfo{
+			Name:   spec.DisplayName(),
+			Source: spec.Common().From,
+			Ref:    spec.Common().Ref,
+			Status: StatusNotInstalled,
+		}
+		if st, ok := installed[spec.Unique()]; ok {
+			p.InstalledRef = st.Ref
+			p.Path = st.Path
+			p.InstalledAt = st.InstalledAt
+			p.Status = StatusInstalled
+		}
+		pkgs = append(pkgs, p)
+	}
+	for _, st := range states.States {
+		if inConfig[st.Spec.Unique()] {
+			continue
+		}
+		pkgs = append(pkgs, &PackageInfo{
+			Name:         st.Spec.DisplayName(),
+			Source:       st.Spec.Common().From,
+			InstalledRef: st.Ref,
+			Path:         st.Path,
+			InstalledAt:  st.InstalledAt,
+			Status:       StatusOrphaned,
+		})
+	}
+	return pkgs
+}
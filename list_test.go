@@ -0,0 +1,44 @@
+package gpkg
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListPackages(t *testing.T) {
+	cfg := &Config{CachePath: t.TempDir()}
+	installed := newPruneTestSpec(t, cfg, "installed")
+	installed.Ref = "latest"
+	orphaned := newPruneTestSpec(t, cfg, "orphaned")
+	notInstalled := NewNopSpec("not-installed")
+	notInstalled.config = cfg
+	cfg.Specs = []PackageSpec{installed, notInstalled}
+
+	states := &StateData{}
+	states.Upsert(orphaned, "v0.1.0", "")
+	states.Upsert(installed, "v1.0.0", "")
+
+	pkgs := ListPackages(cfg, states)
+	require.Len(t, pkgs, 3)
+
+	assert.Equal(t, "installed", pkgs[0].Name)
+	assert.Equal(t, "latest", pkgs[0].Ref)
+	assert.Equal(t, "v1.0.0", pkgs[0].InstalledRef)
+	assert.Equal(t, filepath.Join(installed.PackagePath(), currentVersionName), pkgs[0].Path)
+	assert.NotNil(t, pkgs[0].InstalledAt)
+	assert.Equal(t, StatusInstalled, pkgs[0].Status)
+
+	assert.Equal(t, "not-installed", pkgs[1].Name)
+	assert.Empty(t, pkgs[1].InstalledRef)
+	assert.Empty(t, pkgs[1].Path)
+	assert.Nil(t, pkgs[1].InstalledAt)
+	assert.Equal(t, StatusNotInstalled, pkgs[1].Status)
+
+	assert.Equal(t, "orphaned", pkgs[2].Name)
+	assert.Empty(t, pkgs[2].Ref)
+	assert.Equal(t, "v0.1.0", pkgs[2].InstalledRef)
+	assert.Equal(t, StatusOrphaned, pkgs[2].Status)
+}
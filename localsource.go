@@ -0,0 +1,215 @@
+package gpkg
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	LocalCheckHash  = "hash"
+	LocalCheckMtime = "mtime"
+)
+
+// LocalSource is a source installing a directory, a binary or an archive on
+// the local filesystem, e.g. for offline hosts receiving packages out of band.
+type LocalSource struct {
+	path  string
+	check string
+}
+
+// FileDownloader reads a file on the local filesystem.
+type FileDownloader struct {
+	*os.File
+	name string
+	size int64
+}
+
+var _ Downloader = &FileDownloader{}
+
+func (dl *FileDownloader) GetAssetName() string {
+	return dl.name
+}
+
+func (dl *FileDownloader) GetContentLength() int64 {
+	return dl.size
+}
+
+// fetchRelated reads ref relative to the directory of the file.
+func (dl *FileDownloader) fetchRelated(ref string) ([]byte, error) {
+	if !filepath.IsAbs(ref) {
+		ref = filepath.Join(filepath.Dir(dl.Name()), ref)
+	}
+	return os.ReadFile(ref)
+}
+
+func NewLocalSource(path, check string) (*LocalSource, error) {
+	switch check {
+	case "":
+		check = LocalCheckHash
+	case LocalCheckHash, LocalCheckMtime:
+	default:
+		return nil, fmt.Errorf("invalid check. check=%s", check)
+	}
+
+	abs, err := filepath.Abs(expandPath(path))
+	if err != nil {
+		return nil, err
+	}
+	return newLocalSource(abs, check), nil
+}
+
+// newLocalSource is like NewLocalSource but takes an absolute path, with a
+// valid check.
+func newLocalSource(path, check string) *LocalSource {
+	if check == "" {
+		check = LocalCheckHash
+	}
+	return &LocalSource{
+		path:  path,
+		check: check,
+	}
+}
+
+// expandPath expands environment variables and a leading ~ in path.
+func expandPath(path string) string {
+	path = os.ExpandEnv(path)
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+func (s *LocalSource) GetDownloader() (Downloader, error) {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		f, err := os.Open(s.path)
+		if err != nil {
+			return nil, err
+		}
+		return &FileDownloader{
+			File: f,
+			name: fi.Name(),
+			size: fi.Size(),
+		}, nil
+	}
+
+	return newStreamDownloader(fi.Name()+".tar", func(w io.Writer) error {
+		return writeTar(w, s.path)
+	}), nil
+}
+
+// writeTar writes the contents of the directory root to w as a tar archive.
+func writeTar(w io.Writer, root string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rp, err := filepath.Rel(root, path)
+		if err != nil || rp == "." {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		th, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		th.Name = filepath.ToSlash(rp)
+		if fi.IsDir() {
+			th.Name += "/"
+		}
+		if err := tw.WriteHeader(th); err != nil {
+			return err
+		}
+
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// fingerprint identifies the current contents of the path, either by hashing
+// every file or by the latest modification time.
+func (s *LocalSource) fingerprint() (string, error) {
+	h := sha256.New()
+	var latest time.Time
+	err := filepath.WalkDir(s.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+		if s.check != LocalCheckHash {
+			return nil
+		}
+
+		rp, _ := filepath.Rel(s.path, path)
+		fmt.Fprintf(h, "%s\x00%s\x00", filepath.ToSlash(rp), fi.Mode())
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if s.check == LocalCheckMtime {
+		return "mtime:" + latest.UTC().Format(time.RFC3339Nano), nil
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+func (s *LocalSource) ShouldUpdate(currentRef string) (bool, string, error) {
+	ref, err := s.fingerprint()
+	if err != nil {
+		return false, "", err
+	}
+	return ref != currentRef, ref, nil
+}
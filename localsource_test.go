@@ -0,0 +1,123 @@
+package gpkg
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLocalSource(t *testing.T) {
+	t.Run("default check", func(t *testing.T) {
+		s, err := NewLocalSource("/tmp/foo", "")
+		require.NoError(t, err)
+		assert.Equal(t, LocalCheckHash, s.check)
+	})
+	t.Run("expand home", func(t *testing.T) {
+		home, err := os.UserHomeDir()
+		require.NoError(t, err)
+		s, err := NewLocalSource("~/foo", LocalCheckMtime)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(home, "foo"), s.path)
+	})
+	t.Run("invalid check", func(t *testing.T) {
+		_, err := NewLocalSource("/tmp/foo", "size")
+		require.Error(t, err)
+	})
+}
+
+func TestLocalSource_GetDownloader(t *testing.T) {
+	t.Run("directory", func(t *testing.T) {
+		src := mkdirTestPackage(t, []string{"bin/foo", "README"})
+		defer os.RemoveAll(src)
+
+		s, err := NewLocalSource(src, "")
+		require.NoError(t, err)
+		dl, err := s.GetDownloader()
+		require.NoError(t, err)
+		defer dl.Close()
+
+		dst := t.TempDir()
+		require.NoError(t, extract(dl, dst, dl.GetAssetName(), extractOptions{}))
+		assertDirectoryContents(t, dst, []string{"", "README", "bin", "bin/foo"})
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		src := filepath.Join(t.TempDir(), "foo")
+		require.NoError(t, os.WriteFile(src, []byte("#!/bin/sh\n"), 0755))
+
+		s, err := NewLocalSource(src, "")
+		require.NoError(t, err)
+		dl, err := s.GetDownloader()
+		require.NoError(t, err)
+		defer dl.Close()
+		assert.Equal(t, "foo", dl.GetAssetName())
+		assert.Equal(t, int64(len("#!/bin/sh\n")), dl.GetContentLength())
+
+		dst := t.TempDir()
+		require.NoError(t, extract(dl, dst, dl.GetAssetName(), extractOptions{}))
+		assertDirectoryContents(t, dst, []string{"", "foo"})
+	})
+
+	t.Run("archive", func(t *testing.T) {
+		src := filepath.Join(t.TempDir(), "foo.tar.gz")
+		archive := makeTarGz(t, []*tar.Header{
+			{Name: "foo", Typeflag: tar.TypeDir},
+			{Name: "foo/bar", Typeflag: tar.TypeReg},
+		})
+		require.NoError(t, os.WriteFile(src, archive.Bytes(), 0644))
+
+		s, err := NewLocalSource(src, "")
+		require.NoError(t, err)
+		dl, err := s.GetDownloader()
+		require.NoError(t, err)
+		defer dl.Close()
+
+		dst := t.TempDir()
+		require.NoError(t, extract(dl, dst, dl.GetAssetName(), extractOptions{}))
+		assertDirectoryContents(t, dst, []string{"", "foo", "foo/bar"})
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		s, err := NewLocalSource(filepath.Join(t.TempDir(), "foo"), "")
+		require.NoError(t, err)
+		_, err = s.GetDownloader()
+		require.Error(t, err)
+	})
+}
+
+func TestLocalSource_ShouldUpdate(t *testing.T) {
+	for _, check := range []string{LocalCheckHash, LocalCheckMtime} {
+		t.Run(check, func(t *testing.T) {
+			src := mkdirTestPackage(t, []string{"bin/foo"})
+			defer os.RemoveAll(src)
+
+			s, err := NewLocalSource(src, check)
+			require.NoError(t, err)
+
+			yes, ref, err := s.ShouldUpdate("")
+			require.NoError(t, err)
+			assert.True(t, yes)
+
+			yes, next, err := s.ShouldUpdate(ref)
+			require.NoError(t, err)
+			assert.False(t, yes)
+			assert.Equal(t, ref, next)
+
+			// Modify the file and make sure its mtime moves forward.
+			p := filepath.Join(src, "bin/foo")
+			require.NoError(t, os.WriteFile(p, []byte("updated"), 0644))
+			mtime := time.Now().Add(time.Minute)
+			require.NoError(t, os.Chtimes(p, mtime, mtime))
+
+			yes, next, err = s.ShouldUpdate(ref)
+			require.NoError(t, err)
+			assert.True(t, yes)
+			assert.NotEqual(t, ref, next)
+		})
+	}
+}
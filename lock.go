@@ -0,0 +1,308 @@
+package gpkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// LockFileName is the name of the lock file, which is placed next to the
+// config file.
+const LockFileName = "gpkg.lock"
+
+// LockData pins the artifact installed for each package, so that every
+// machine sharing a config installs the same ones.
+// LockData is safe for concurrent use by multiple goroutines.
+type LockData struct {
+	Packages []*LockedPackage `json:"packages"`
+
+	mu sync.Mutex
+}
+
+// LockedPackage is the ref a package resolved to and the artifacts it
+// installs, keyed by os/arch. A package generated on the fly, such as a
+// snapshot of a git repository, is pinned by its ref alone.
+type LockedPackage struct {
+	Name      string                     `json:"name"`
+	Ref       string                     `json:"ref"`
+	Artifacts map[string]*LockedArtifact `json:"artifacts,omitempty"`
+}
+
+// LockedArtifact is the file downloaded for a package on a platform.
+type LockedArtifact struct {
+	Asset  string `json:"asset"`
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+}
+
+func LoadLockDataFromFile(path string) (*LockData, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &LockData{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	ld := &LockData{}
+	if err := json.NewDecoder(f).Decode(ld); err != nil {
+		return nil, fmt.Errorf("Error decoding json in a lock file. err=%v", err)
+	}
+	return ld, nil
+}
+
+// Save writes the lock data sorted by name, so that it diffs well.
+func (ld *LockData) Save(w io.Writer) error {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	sort.Slice(ld.Packages, func(i, j int) bool {
+		return ld.Packages[i].Name < ld.Packages[j].Name
+	})
+	bs, err := json.MarshalIndent(ld, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to encode the lock file to JSON. err=%v", err)
+	}
+	if _, err = w.Write(append(bs, '\n')); err != nil {
+		return fmt.Errorf("Failed to write a lock file: err=%v", err)
+	}
+	return nil
+}
+
+func (ld *LockData) SaveToFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return ld.Save(f)
+}
+
+// Find returns the package locked for spec, or nil if it is not locked.
+func (ld *LockData) Find(spec PackageSpec) *LockedPackage {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	for _, lp := range ld.Packages {
+		if lp.Name == spec.Unique() {
+			return lp
+		}
+	}
+	return nil
+}
+
+func (ld *LockData) Upsert(lp *LockedPackage) {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	for i, p := range ld.Packages {
+		if p.Name == lp.Name {
+			ld.Packages[i] = lp
+			return
+		}
+	}
+	ld.Packages = append(ld.Packages, lp)
+}
+
+// Retain drops the packages which are not in specs.
+func (ld *LockData) Retain(specs []PackageSpec) {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	names := map[string]bool{}
+	for _, spec := range specs {
+		names[spec.Unique()] = true
+	}
+	var kept []*LockedPackage
+	for _, lp := range ld.Packages {
+		if names[lp.Name] {
+			kept = append(kept, lp)
+		}
+	}
+	ld.Packages = kept
+}
+
+// Platforms returns the platforms some package is locked for.
+func (ld *LockData) Platforms() []string {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	seen := map[string]bool{}
+	var platforms []string
+	for _, lp := range ld.Packages {
+		for p := range lp.Artifacts {
+			if !seen[p] {
+				seen[p] = true
+				platforms = append(platforms, p)
+			}
+		}
+	}
+	sort.Strings(platforms)
+	return platforms
+}
+
+// HostLockPlatform is the platform gpkg runs on, in the form used in the
+// lock file.
+func HostLockPlatform() string {
+	return runtime.GOOS + "/" + runtime.GOARCH
+}
+
+// ParseLockPlatform splits a platform given as os/arch.
+func ParseLockPlatform(s string) (string, string, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid platform %q. expected os/arch", s)
+	}
+	return parts[0], parts[1], nil
+}
+
+// LockPackage resolves the ref of spec and the artifacts it installs on each
+// of platforms, given as os/arch, without installing anything. Local packages
+// differ between machines, so nil is returned for them.
+func LockPackage(spec PackageSpec, platforms []string) (*LockedPackage, error) {
+	if _, ok := spec.(*LocalSpec); ok {
+		return nil, nil
+	}
+
+	src, err := getSource(spec)
+	if err != nil {
+		return nil, err
+	}
+	_, ref, err := src.ShouldUpdate("")
+	if err != nil {
+		return nil, err
+	}
+
+	lp := &LockedPackage{
+		Name: spec.Unique(),
+		Ref:  ref,
+	}
+	// A snapshot of a git repository is the same on any platform, and pinned
+	// by the commit.
+	if _, ok := spec.(*GitSpec); ok {
+		return lp, nil
+	}
+	for _, p := range platforms {
+		goos, goarch, err := ParseLockPlatform(p)
+		if err != nil {
+			return nil, err
+		}
+		a, err := lockArtifact(spec, ref, goos, goarch)
+		if err != nil {
+			return nil, fmt.Errorf("%s (%s)", err, p)
+		}
+		if a == nil {
+			// Nothing but the ref is worth locking.
+			break
+		}
+		if lp.Artifacts == nil {
+			lp.Artifacts = map[string]*LockedArtifact{}
+		}
+		lp.Artifacts[p] = a
+	}
+	return lp, nil
+}
+
+// lockArtifact returns the artifact spec downloads at ref on goos and goarch,
+// or nil if it is not downloaded from a URL. The asset is only downloaded to
+// hash it if neither the spec nor the release publishes its digest.
+func lockArtifact(spec PackageSpec, ref, goos, goarch string) (*LockedArtifact, error) {
+	src, err := newSource(spec, ref, goos, goarch)
+	if err != nil {
+		return nil, err
+	}
+	ar, ok := src.(assetResolver)
+	if !ok {
+		return nil, nil
+	}
+	ra, err := ar.resolveAsset()
+	if err != nil {
+		return nil, err
+	}
+
+	a := &LockedArtifact{
+		Asset:  ra.name,
+		URL:    ra.url,
+		SHA256: spec.Common().SHA256,
+	}
+	if a.SHA256 == "" {
+		a.SHA256 = ra.sha256
+	}
+	if a.SHA256 == "" {
+		dl, err := ra.open()
+		if err != nil {
+			return nil, err
+		}
+		defer dl.Close()
+		dr := newDigestReader(dl)
+		if _, err := io.Copy(io.Discard, dr); err != nil {
+			return nil, err
+		}
+		a.SHA256 = dr.Sum()
+	}
+	return a, nil
+}
+
+// reGitCommit matches a full SHA-1 or SHA-256 object name.
+var reGitCommit = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// checkLockedRef reports a ref in spec which the locked one no longer
+// satisfies, which means that the lock file is stale.
+func checkLockedRef(spec PackageSpec, lp *LockedPackage) error {
+	// The ref of a git repository is locked as a commit, which is passed to
+	// git as is.
+	if _, ok := spec.(*GitSpec); ok {
+		if !reGitCommit.MatchString(lp.Ref) {
+			return fmt.Errorf("The locked ref %q is not a commit. Run gpkg lock to refresh the lock file", lp.Ref)
+		}
+		return nil
+	}
+
+	ref := spec.Common().Ref
+	ok := true
+	switch {
+	case isVersionConstraint(ref):
+		c, err := parseVersionConstraint(ref)
+		if err != nil {
+			return err
+		}
+		v, _, err := parseSemver(lp.Ref)
+		ok = err == nil && c.check(v, spec.Common().Prerelease)
+	case isFloatingRef(ref):
+	default:
+		ok = ref == lp.Ref
+	}
+	if !ok {
+		return fmt.Errorf("The locked ref %s does not match ref %s in the config. Run gpkg lock to refresh the lock file", lp.Ref, ref)
+	}
+	return nil
+}
+
+// checkLockedArtifact reports the artifact about to be downloaded if it is
+// not the one locked. a is the artifact locked for the host, if any.
+func checkLockedArtifact(spec PackageSpec, a *LockedArtifact, dl Downloader) error {
+	hd, ok := dl.(*HTTPDownloader)
+	if !ok {
+		// Pinned by the ref alone
+		return nil
+	}
+	if a == nil {
+		return fmt.Errorf("Not locked for %s. Run gpkg lock --platform %s", HostLockPlatform(), HostLockPlatform())
+	}
+	if hd.GetAssetName() != a.Asset || hd.url != a.URL {
+		return fmt.Errorf("The asset has drifted from the lock file. locked=%s, got=%s", a.URL, hd.url)
+	}
+	if s := spec.Common().SHA256; s != "" && !strings.EqualFold(s, a.SHA256) {
+		return fmt.Errorf("The sha256 in the config differs from the lock file. Run gpkg lock to refresh the lock file")
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package gpkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestLockData_SaveToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), LockFileName)
+
+	ld, err := LoadLockDataFromFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, ld.Packages)
+
+	ld.Upsert(&LockedPackage{Name: "foo/bar", Ref: "v1.0.0", Artifacts: map[string]*LockedArtifact{
+		"linux/amd64": {Asset: "bar-linux-amd64", URL: "https://example.com/bar-linux-amd64", SHA256: sha256Hex("bar")},
+	}})
+	ld.Upsert(&LockedPackage{Name: "foo/baz", Ref: "v2.0.0", Artifacts: map[string]*LockedArtifact{
+		"darwin/arm64": {Asset: "baz-darwin-arm64", URL: "https://example.com/baz-darwin-arm64", SHA256: sha256Hex("baz")},
+	}})
+	ld.Upsert(&LockedPackage{Name: "foo/bar", Ref: "v1.1.0"})
+	ld.Upsert(&LockedPackage{Name: "foo/abc", Ref: "v0.1.0"})
+	require.NoError(t, ld.SaveToFile(path))
+
+	got, err := LoadLockDataFromFile(path)
+	require.NoError(t, err)
+	require.Len(t, got.Packages, 3)
+	// Sorted by name
+	assert.Equal(t, "foo/abc", got.Packages[0].Name)
+	assert.Equal(t, "foo/bar", got.Packages[1].Name)
+	assert.Equal(t, "v1.1.0", got.Packages[1].Ref)
+	assert.Equal(t, []string{"darwin/arm64"}, got.Platforms())
+
+	cfg := &Config{}
+	specs := []PackageSpec{
+		&GitHubReleaseSpec{CommonSpec: &CommonSpec{config: cfg}, Repo: "foo/baz"},
+		&GitHubReleaseSpec{CommonSpec: &CommonSpec{config: cfg}, Repo: "foo/new"},
+	}
+	assert.Equal(t, "v2.0.0", got.Find(specs[0]).Ref)
+	assert.Nil(t, got.Find(specs[1]))
+	got.Retain(specs)
+	require.Len(t, got.Packages, 1)
+	assert.Equal(t, "foo/baz", got.Packages[0].Name)
+}
+
+func TestParseLockPlatform(t *testing.T) {
+	goos, goarch, err := ParseLockPlatform("linux/arm64")
+	require.NoError(t, err)
+	assert.Equal(t, "linux", goos)
+	assert.Equal(t, "arm64", goarch)
+
+	for _, s := range []string{"linux", "linux/", "/arm64", "linux/arm64/v8"} {
+		_, _, err := ParseLockPlatform(s)
+		assert.Error(t, err, s)
+	}
+}
+
+// newLockTestSpec returns a spec of the release v1.0.0 served by a Gitea
+// stand-in, which has a binary for the host and for darwin/arm64. The content
+// of each asset is its name.
+func newLockTestSpec(t *testing.T, ref string) *GiteaReleaseSpec {
+	srv := newGiteaTestServer(t, "owner/foo", "", []*giteaRelease{
+		newGiteaTestRelease("v1.0.0",
+			fmt.Sprintf("foo-v1.0.0-%s-%s", runtime.GOOS, runtime.GOARCH),
+			"foo-v1.0.0-darwin-arm64",
+		),
+	})
+	return &GiteaReleaseSpec{
+		CommonSpec: &CommonSpec{From: "gitea", Ref: ref, config: &Config{CachePath: t.TempDir()}},
+		Host:       srv.URL,
+		Repo:       "owner/foo",
+	}
+}
+
+func TestLockPackage(t *testing.T) {
+	spec := newLockTestSpec(t, "latest")
+	hostAsset := fmt.Sprintf("foo-v1.0.0-%s-%s", runtime.GOOS, runtime.GOARCH)
+
+	lp, err := LockPackage(spec, []string{HostLockPlatform(), "darwin/arm64"})
+	require.NoError(t, err)
+	assert.Equal(t, spec.Unique(), lp.Name)
+	assert.Equal(t, "v1.0.0", lp.Ref)
+	require.Len(t, lp.Artifacts, 2)
+
+	a := lp.Artifacts[HostLockPlatform()]
+	assert.Equal(t, hostAsset, a.Asset)
+	assert.True(t, strings.HasSuffix(a.URL, "/assets/"+hostAsset))
+	assert.Equal(t, sha256Hex(hostAsset), a.SHA256)
+
+	a = lp.Artifacts["darwin/arm64"]
+	assert.Equal(t, "foo-v1.0.0-darwin-arm64", a.Asset)
+	assert.Equal(t, sha256Hex("foo-v1.0.0-darwin-arm64"), a.SHA256)
+
+	t.Run("no asset for the platform", func(t *testing.T) {
+		_, err := LockPackage(spec, []string{"plan9/386"})
+		require.Error(t, err)
+	})
+
+	t.Run("local", func(t *testing.T) {
+		lp, err := LockPackage(&LocalSpec{CommonSpec: &CommonSpec{From: "local"}, Path: t.TempDir()}, nil)
+		require.NoError(t, err)
+		assert.Nil(t, lp)
+	})
+}
+
+func TestLockPackage_DownloadsOnlyToHash(t *testing.T) {
+	var mu sync.Mutex
+	downloads := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		downloads[r.URL.Path]++
+		mu.Unlock()
+		w.Write([]byte(r.URL.Path))
+	}))
+	t.Cleanup(srv.Close)
+	platforms := []string{"linux/amd64", "darwin/arm64"}
+
+	t.Run("digest in the spec", func(t *testing.T) {
+		downloads = map[string]int{}
+		spec := &URLSpec{
+			CommonSpec: &CommonSpec{From: "url", ID: "foo", Ref: "1.0.0", SHA256: sha256Hex("foo")},
+			URL:        srv.URL + "/{{.Version}}/foo-{{.OS}}-{{.Arch}}",
+		}
+		lp, err := LockPackage(spec, platforms)
+		require.NoError(t, err)
+		require.Len(t, lp.Artifacts, 2)
+		assert.Equal(t, srv.URL+"/1.0.0/foo-darwin-arm64", lp.Artifacts["darwin/arm64"].URL)
+		assert.Empty(t, downloads)
+	})
+
+	t.Run("digest unknown", func(t *testing.T) {
+		downloads = map[string]int{}
+		spec := &URLSpec{
+			CommonSpec: &CommonSpec{From: "url", ID: "foo", Ref: "1.0.0"},
+			URL:        srv.URL + "/{{.Version}}/foo-{{.OS}}-{{.Arch}}",
+		}
+		lp, err := LockPackage(spec, platforms)
+		require.NoError(t, err)
+		assert.Equal(t, sha256Hex("/1.0.0/foo-linux-amd64"), lp.Artifacts["linux/amd64"].SHA256)
+		assert.Equal(t, map[string]int{"/1.0.0/foo-linux-amd64": 1, "/1.0.0/foo-darwin-arm64": 1}, downloads)
+	})
+}
+
+func TestCheckLockedRef_Git(t *testing.T) {
+	spec := &GitSpec{CommonSpec: &CommonSpec{From: "git", Ref: "main"}, URL: "https://github.com/foo/bar"}
+	tests := []struct {
+		ref     string
+		recvErr bool
+	}{
+		{strings.Repeat("a", 40), false},
+		{strings.Repeat("a", 64), false},
+		{"main", true},
+		{strings.Repeat("a", 12), true},
+		{"--upload-pack=touch /tmp/x;git-upload-pack", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			err := checkLockedRef(spec, &LockedPackage{Name: spec.Unique(), Ref: tt.ref})
+			if tt.recvErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func reconcileLockedTestPackage(states *StateData, lock *LockData, spec PackageSpec) error {
+	ch := make(chan *Event)
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(done)
+	}()
+	err := ReconcileLockedPackage(spec.Common().config.GetPackagesPath(), states, lock, spec, ch, io.Discard)
+	close(ch)
+	<-done
+	return err
+}
+
+func TestReconcileLockedPackage(t *testing.T) {
+	hostAsset := fmt.Sprintf("foo-v1.0.0-%s-%s", runtime.GOOS, runtime.GOARCH)
+	newLock := func(t *testing.T, spec PackageSpec, modify func(lp *LockedPackage)) *LockData {
+		lp, err := LockPackage(spec, []string{HostLockPlatform()})
+		require.NoError(t, err)
+		if modify != nil {
+			modify(lp)
+		}
+		return &LockData{Packages: []*LockedPackage{lp}}
+	}
+
+	t.Run("locked", func(t *testing.T) {
+		spec := newLockTestSpec(t, "latest")
+		states := &StateData{}
+		require.NoError(t, reconcileLockedTestPackage(states, newLock(t, spec, nil), spec))
+		assert.FileExists(t, filepath.Join(spec.PackagePath(), currentVersionName, hostAsset))
+		_, state, err := states.FindState(spec)
+		require.NoError(t, err)
+		assert.Equal(t, "v1.0.0", state.Ref)
+		assert.Equal(t, sha256Hex(hostAsset), state.SHA256)
+	})
+
+	t.Run("reinstalled from the locked artifact", func(t *testing.T) {
+		spec := newLockTestSpec(t, "latest")
+		states := &StateData{}
+		states.Upsert(spec, "v1.0.0", sha256Hex("something else"))
+		require.NoError(t, reconcileLockedTestPackage(states, newLock(t, spec, nil), spec))
+		assert.FileExists(t, filepath.Join(spec.PackagePath(), currentVersionName, hostAsset))
+	})
+
+	tests := []struct {
+		name   string
+		ref    string
+		modify func(lp *LockedPackage)
+	}{
+		{"not locked", "latest", func(lp *LockedPackage) { lp.Name = "owner/other" }},
+		{"not locked for the host", "latest", func(lp *LockedPackage) { lp.Artifacts = nil }},
+		{"asset drifted", "latest", func(lp *LockedPackage) { lp.Artifacts[HostLockPlatform()].URL += ".old" }},
+		{"checksum drifted", "latest", func(lp *LockedPackage) { lp.Artifacts[HostLockPlatform()].SHA256 = sha256Hex("old") }},
+		{"stale ref", "v1.0.0", func(lp *LockedPackage) { lp.Ref = "v0.9.0" }},
+		{"stale constraint", "^1", func(lp *LockedPackage) { lp.Ref = "v0.9.0" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := newLockTestSpec(t, tt.ref)
+			lock := newLock(t, spec, tt.modify)
+			states := &StateData{}
+			require.Error(t, reconcileLockedTestPackage(states, lock, spec))
+			assert.NoDirExists(t, spec.PackagePath())
+			assert.Empty(t, states.States)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package gpkg
+
+// UpdateInfo is the ref of a package installed and the one update would
+// install.
+type UpdateInfo struct {
+	Name string `json:"name"`
+	// Current is empty if the package is not installed.
+	Current  string `json:"current"`
+	Next     string `json:"next"`
+	Outdated bool   `json:"outdated"`
+}
+
+// updateChecker is implemented by sources which download into a cache to
+// resolve their ref in ShouldUpdate, and can tell the ref without it.
+type updateChecker interface {
+	checkUpdate(currentRef string) (bool, string, error)
+}
+
+var _ updateChecker = &GitRepository{}
+
+// CheckUpdate resolves the ref of spec and reports whether it differs from
+// the one installed, without downloading the package.
+func CheckUpdate(states *StateData, spec PackageSpec) (*UpdateInfo, error) {
+	src, err := getSource(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var currentRef string
+	_, state, _ := states.FindState(spec)
+	if state != nil {
+		currentRef = state.Ref
+	}
+	check := src.ShouldUpdate
+	if uc, ok := src.(updateChecker); ok {
+		check = uc.checkUpdate
+	}
+	yes, nextRef, err := check(currentRef)
+	if err != nil {
+		return nil, err
+	}
+	return &UpdateInfo{
+		Name:     spec.DisplayName(),
+		Current:  currentRef,
+		Next:     nextRef,
+		Outdated: yes,
+	}, nil
+}
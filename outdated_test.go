@@ -0,0 +1,41 @@
+package gpkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  string
+		expected *UpdateInfo
+	}{
+		{"not installed", "", &UpdateInfo{Next: "v1.0.0", Outdated: true}},
+		{"outdated", "v0.9.0", &UpdateInfo{Current: "v0.9.0", Next: "v1.0.0", Outdated: true}},
+		{"up to date", "v1.0.0", &UpdateInfo{Current: "v1.0.0", Next: "v1.0.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := newLockTestSpec(t, "latest")
+			states := &StateData{}
+			if tt.current != "" {
+				states.Upsert(spec, tt.current, "")
+			}
+			tt.expected.Name = spec.DisplayName()
+
+			got, err := CheckUpdate(states, spec)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+			// Nothing is downloaded
+			assert.NoDirExists(t, spec.PackagePath())
+		})
+	}
+
+	t.Run("no release", func(t *testing.T) {
+		_, err := CheckUpdate(&StateData{}, newLockTestSpec(t, "^2"))
+		require.Error(t, err)
+	})
+}
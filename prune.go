@@ -0,0 +1,118 @@
+package gpkg
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Orphan is a package installed, or a repository cached, for a spec which is
+// no longer in the config.
+type Orphan struct {
+	Name string
+	Path string
+	// State is nil for a directory which no state refers to.
+	State *State
+	// InUse is set when Path is also the directory of a package in the
+	// config, such as a spec whose path has been rewritten, in which case only
+	// the state is forgotten.
+	InUse bool
+}
+
+// FindOrphans returns the states of packages which are not in cfg, and the
+// directories under the cache path which no package in cfg uses.
+func FindOrphans(cfg *Config, states *StateData) ([]Orphan, error) {
+	specs := map[string]bool{}
+	inConfig := map[string]bool{}
+	used := map[string]bool{}
+	for _, spec := range cfg.Specs {
+		specs[spec.Unique()] = true
+		inConfig[filepath.Clean(spec.PackagePath())] = true
+		used[filepath.Clean(spec.PackagePath())] = true
+		if gs, ok := spec.(*GitSpec); ok {
+			used[filepath.Clean(gs.RepositoryCachePath())] = true
+		}
+	}
+
+	var orphans []Orphan
+	states.mu.Lock()
+	for _, st := range states.States {
+		st := st
+		used[filepath.Clean(packageDir(st))] = true
+		if !specs[st.Spec.Unique()] {
+			orphans = append(orphans, Orphan{
+				Name:  st.Spec.DisplayName(),
+				Path:  packageDir(st),
+				State: &st,
+				InUse: inConfig[filepath.Clean(packageDir(st))],
+			})
+		}
+	}
+	states.mu.Unlock()
+
+	for _, dir := range []string{cfg.GetPackagesPath(), filepath.Join(cfg.CachePath, "git")} {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			p := filepath.Join(dir, e.Name())
+			if !used[p] {
+				orphans = append(orphans, Orphan{Name: e.Name(), Path: p})
+			}
+		}
+	}
+	return orphans, nil
+}
+
+// RemoveOrphan deletes the files of o and forgets its state.
+func RemoveOrphan(cfg *Config, states *StateData, o Orphan) error {
+	if o.State != nil {
+		states.Remove(o.State.Spec)
+	}
+	if o.InUse {
+		return nil
+	}
+	return removePackageDir(cfg, o.Path)
+}
+
+// UninstallPackage deletes the package installed for spec and forgets its
+// state. The bare clone of a git repository is deleted along with it, unless
+// another package in cfg is installed from the same repository.
+func UninstallPackage(cfg *Config, states *StateData, spec PackageSpec) error {
+	_, st, err := states.FindState(spec)
+	if err != nil {
+		return fmt.Errorf("%s is not installed", spec.DisplayName())
+	}
+	states.Remove(spec)
+	if err := removePackageDir(cfg, packageDir(*st)); err != nil {
+		return err
+	}
+
+	gs, ok := st.Spec.(*GitSpec)
+	if !ok {
+		return nil
+	}
+	for _, other := range cfg.Specs {
+		if o, ok := other.(*GitSpec); ok && o.dir() == gs.dir() && o.Unique() != gs.Unique() {
+			return nil
+		}
+	}
+	return removePackageDir(cfg, filepath.Join(cfg.CachePath, "git", gs.dir()))
+}
+
+// removePackageDir deletes p, which must be under the cache path. A state
+// file edited by hand, or written with another cache path, may point anywhere
+// else.
+func removePackageDir(cfg *Config, p string) error {
+	rel, err := filepath.Rel(cfg.CachePath, p)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("Refusing to remove %s, which is outside of %s", p, cfg.CachePath)
+	}
+	return os.RemoveAll(p)
+}
@@ -0,0 +1,135 @@
+package gpkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newPruneTestSpec returns a spec of cfg installed under its packages path.
+func newPruneTestSpec(t *testing.T, cfg *Config, id string) *NopSpec {
+	spec := NewNopSpec(id)
+	spec.config = cfg
+	require.NoError(t, os.MkdirAll(spec.PackagePath(), 0755))
+	return spec
+}
+
+func TestFindOrphans(t *testing.T) {
+	cfg := &Config{CachePath: t.TempDir()}
+	kept := newPruneTestSpec(t, cfg, "kept")
+	removed := newPruneTestSpec(t, cfg, "removed")
+	notInstalled := NewNopSpec("not-installed")
+	notInstalled.config = cfg
+	cfg.Specs = []PackageSpec{kept, notInstalled}
+
+	states := &StateData{}
+	states.Upsert(kept, "v1", "")
+	states.Upsert(removed, "v1", "")
+	stray := filepath.Join(cfg.GetPackagesPath(), "stray")
+	require.NoError(t, os.MkdirAll(stray, 0755))
+	clone := filepath.Join(cfg.CachePath, "git", "example.com---foo")
+	require.NoError(t, os.MkdirAll(clone, 0755))
+
+	orphans, err := FindOrphans(cfg, states)
+	require.NoError(t, err)
+	require.Len(t, orphans, 3)
+	assert.Equal(t, "removed", orphans[0].Name)
+	assert.Equal(t, removed.PackagePath(), orphans[0].Path)
+	require.NotNil(t, orphans[0].State)
+	assert.False(t, orphans[0].InUse)
+	assert.Equal(t, "stray", orphans[1].Name)
+	assert.Nil(t, orphans[1].State)
+	assert.Equal(t, clone, orphans[2].Path)
+
+	for _, o := range orphans {
+		require.NoError(t, RemoveOrphan(cfg, states, o))
+		assert.NoDirExists(t, o.Path)
+	}
+	require.Len(t, states.States, 1)
+	assert.Equal(t, "kept", states.States[0].Spec.Unique())
+	assert.DirExists(t, kept.PackagePath())
+
+	orphans, err = FindOrphans(cfg, states)
+	require.NoError(t, err)
+	assert.Empty(t, orphans)
+}
+
+func TestFindOrphans_InUse(t *testing.T) {
+	cfg := &Config{CachePath: t.TempDir()}
+	kept := newPruneTestSpec(t, cfg, "kept")
+	cfg.Specs = []PackageSpec{kept}
+
+	// A state written for the same package under another name
+	old := NewNopSpec("old")
+	old.config = cfg
+	states := &StateData{States: []State{{Spec: old, Path: kept.PackagePath(), Ref: "v1"}}}
+	states.Upsert(kept, "v1", "")
+
+	orphans, err := FindOrphans(cfg, states)
+	require.NoError(t, err)
+	require.Len(t, orphans, 1)
+	assert.Equal(t, "old", orphans[0].Name)
+	assert.True(t, orphans[0].InUse)
+
+	require.NoError(t, RemoveOrphan(cfg, states, orphans[0]))
+	assert.DirExists(t, kept.PackagePath())
+	require.Len(t, states.States, 1)
+	assert.Equal(t, "kept", states.States[0].Spec.Unique())
+}
+
+func TestFindOrphans_NothingInstalled(t *testing.T) {
+	cfg := &Config{CachePath: filepath.Join(t.TempDir(), "missing")}
+	orphans, err := FindOrphans(cfg, &StateData{})
+	require.NoError(t, err)
+	assert.Empty(t, orphans)
+}
+
+func TestUninstallPackage(t *testing.T) {
+	cfg := &Config{CachePath: t.TempDir()}
+	foo := newPruneTestSpec(t, cfg, "foo")
+	states := &StateData{}
+	states.Upsert(foo, "v1", "")
+
+	require.NoError(t, UninstallPackage(cfg, states, foo))
+	assert.NoDirExists(t, foo.PackagePath())
+	assert.Empty(t, states.States)
+
+	require.Error(t, UninstallPackage(cfg, states, foo))
+}
+
+func TestUninstallPackage_GitRepository(t *testing.T) {
+	cfg := &Config{CachePath: t.TempDir()}
+	newSpec := func(id string) *GitSpec {
+		spec := &GitSpec{CommonSpec: &CommonSpec{From: "git", ID: id, config: cfg}, URL: "https://example.com/foo.git"}
+		require.NoError(t, os.MkdirAll(spec.PackagePath(), 0755))
+		require.NoError(t, os.MkdirAll(spec.RepositoryCachePath(), 0755))
+		return spec
+	}
+	stable, head := newSpec("stable"), newSpec("head")
+	cfg.Specs = []PackageSpec{stable, head}
+	states := &StateData{}
+	states.Upsert(stable, "abc", "")
+	states.Upsert(head, "def", "")
+
+	// The clone is still used by the other package.
+	require.NoError(t, UninstallPackage(cfg, states, stable))
+	assert.NoDirExists(t, stable.PackagePath())
+	assert.DirExists(t, head.RepositoryCachePath())
+
+	cfg.Specs = []PackageSpec{}
+	require.NoError(t, UninstallPackage(cfg, states, head))
+	assert.NoDirExists(t, head.RepositoryCachePath())
+}
+
+func TestRemovePackageDir_OutsideCachePath(t *testing.T) {
+	cfg := &Config{CachePath: filepath.Join(t.TempDir(), "cache")}
+	outside := filepath.Join(filepath.Dir(cfg.CachePath), "outside")
+	require.NoError(t, os.MkdirAll(outside, 0755))
+
+	require.Error(t, removePackageDir(cfg, outside))
+	require.Error(t, removePackageDir(cfg, cfg.CachePath))
+	assert.DirExists(t, outside)
+}
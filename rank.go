@@ -0,0 +1,324 @@
+package gpkg
+
+import (
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// platform is what an asset is selected for. libc is either glibc or musl on
+// Linux, and empty when unknown.
+type platform struct {
+	os   string
+	arch string
+	libc string
+}
+
+// hostPlatform returns the platform gpkg runs on.
+func hostPlatform() platform {
+	return newPlatform(runtime.GOOS, runtime.GOARCH)
+}
+
+// newPlatform returns the platform for goos and goarch. The C library is only
+// detected for the host.
+func newPlatform(goos, goarch string) platform {
+	p := platform{os: goos, arch: goarch}
+	if goos == runtime.GOOS && runtime.GOOS == "linux" {
+		p.libc = hostLibc()
+	}
+	return p
+}
+
+var (
+	hostLibcOnce  sync.Once
+	hostLibcValue string
+)
+
+// hostLibc tells whether the host is linked against musl, which is the case
+// when its dynamic loader exists, or glibc.
+func hostLibc() string {
+	hostLibcOnce.Do(func() {
+		hostLibcValue = "glibc"
+		if m, _ := filepath.Glob("/lib/ld-musl-*.so.1"); len(m) > 0 {
+			hostLibcValue = "musl"
+		}
+	})
+	return hostLibcValue
+}
+
+// osAliases maps the words used for an OS in asset names to GOOS.
+var osAliases = map[string]string{
+	"linux":     "linux",
+	"linux32":   "linux",
+	"linux64":   "linux",
+	"darwin":    "darwin",
+	"macos":     "darwin",
+	"macosx":    "darwin",
+	"osx":       "darwin",
+	"mac":       "darwin",
+	"apple":     "darwin",
+	"windows":   "windows",
+	"win":       "windows",
+	"win32":     "windows",
+	"win64":     "windows",
+	"freebsd":   "freebsd",
+	"openbsd":   "openbsd",
+	"netbsd":    "netbsd",
+	"dragonfly": "dragonfly",
+	"illumos":   "illumos",
+	"solaris":   "solaris",
+	"android":   "android",
+}
+
+// archAliases maps the words used for an architecture in asset names to
+// GOARCH. x86_64 is rewritten to amd64 beforehand so that it is not taken for
+// x86.
+var archAliases = map[string]string{
+	"amd64":    "amd64",
+	"amd64v1":  "amd64",
+	"amd64v2":  "amd64",
+	"amd64v3":  "amd64",
+	"amd64v4":  "amd64",
+	"x64":      "amd64",
+	"linux64":  "amd64",
+	"win64":    "amd64",
+	"arm64":    "arm64",
+	"aarch64":  "arm64",
+	"armv8":    "arm64",
+	"arm":      "arm",
+	"arm32":    "arm",
+	"armv5":    "arm",
+	"armv6":    "arm",
+	"armv6l":   "arm",
+	"armv7":    "arm",
+	"armv7l":   "arm",
+	"armhf":    "arm",
+	"armel":    "arm",
+	"386":      "386",
+	"i386":     "386",
+	"i486":     "386",
+	"i586":     "386",
+	"i686":     "386",
+	"x86":      "386",
+	"ia32":     "386",
+	"linux32":  "386",
+	"win32":    "386",
+	"ppc64":    "ppc64",
+	"ppc64le":  "ppc64le",
+	"s390x":    "s390x",
+	"riscv64":  "riscv64",
+	"mips":     "mips",
+	"mipsle":   "mipsle",
+	"mips64":   "mips64",
+	"mips64le": "mips64le",
+	"loong64":  "loong64",
+}
+
+// universalAliases are the words for macOS binaries built for every
+// architecture.
+var universalAliases = map[string]bool{
+	"universal":  true,
+	"universal2": true,
+}
+
+var (
+	reX8664     = regexp.MustCompile(`x86[-_]64`)
+	reNameDelim = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// assetTokens splits an asset name into lowercase words.
+func assetTokens(name string) []string {
+	name = reX8664.ReplaceAllString(strings.ToLower(name), "amd64")
+	return reNameDelim.Split(name, -1)
+}
+
+// assetFormat is the kind of file an asset is, judging from its extension.
+type assetFormat int
+
+const (
+	formatBinary assetFormat = iota
+	formatArchive
+	formatCompressed
+	formatPackage
+	formatMetadata
+)
+
+var (
+	archiveSuffixes    = []string{".tar.gz", ".tgz", ".tar.xz", ".txz", ".tar.bz2", ".tbz", ".tbz2", ".tar.zst", ".tzst", ".tar", ".zip"}
+	compressedSuffixes = []string{".gz", ".xz", ".bz2", ".zst"}
+	packageSuffixes    = []string{".deb", ".rpm", ".apk", ".pkg", ".msi", ".dmg", ".snap", ".flatpak", ".nupkg", ".7z", ".rar"}
+	metadataSuffixes   = []string{
+		".sha1", ".sha256", ".sha512", ".sha256sum", ".sha512sum", ".md5", ".sum",
+		".sig", ".asc", ".minisig", ".pem", ".crt", ".cert", ".bundle",
+		".sbom", ".spdx", ".spdx.json", ".cdx.json", ".intoto.jsonl", ".json", ".txt",
+	}
+)
+
+func hasAnySuffix(name string, suffixes []string) bool {
+	for _, s := range suffixes {
+		if strings.HasSuffix(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectAssetFormat tells the format of an asset from its name. Anything not
+// recognized is taken for a binary.
+func detectAssetFormat(name string) assetFormat {
+	name = strings.ToLower(name)
+	switch {
+	case hasAnySuffix(name, metadataSuffixes) || reChecksumsFile.MatchString(name) || strings.Contains(name, "sbom"):
+		return formatMetadata
+	case hasAnySuffix(name, archiveSuffixes):
+		return formatArchive
+	case hasAnySuffix(name, compressedSuffixes):
+		return formatCompressed
+	case hasAnySuffix(name, packageSuffixes):
+		return formatPackage
+	}
+	return formatBinary
+}
+
+// assetRanking is how well an asset suits a platform. An asset is rejected,
+// and never selected, if there is any rejection. notes explain the score.
+type assetRanking struct {
+	asset      releaseAsset
+	score      int
+	rejections []string
+	notes      []string
+}
+
+func (r *assetRanking) rejected() bool {
+	return len(r.rejections) > 0
+}
+
+func (r *assetRanking) reject(reason string) {
+	r.rejections = append(r.rejections, reason)
+}
+
+func (r *assetRanking) adjust(score int, note string) {
+	r.score += score
+	r.notes = append(r.notes, note)
+}
+
+// rankAsset scores an asset for p. Assets for another OS or architecture,
+// packages which cannot be extracted, and checksums, signatures or SBOMs are
+// rejected. Archives are preferred over compressed files and bare binaries,
+// builds for the C library of the host over the others, and baseline builds
+// over those requiring a newer CPU.
+func rankAsset(p platform, a releaseAsset) *assetRanking {
+	r := &assetRanking{asset: a}
+
+	oses := map[string]bool{}
+	arches := map[string]bool{}
+	universal := false
+	amd64Variant := false
+	libc := ""
+	static := false
+	for _, t := range assetTokens(a.name) {
+		if os, ok := osAliases[t]; ok {
+			oses[os] = true
+		}
+		if arch, ok := archAliases[t]; ok {
+			arches[arch] = true
+			if arch == "amd64" && strings.HasPrefix(t, "amd64v") && t != "amd64v1" {
+				amd64Variant = true
+			}
+		}
+		if universalAliases[t] {
+			universal = true
+		}
+		switch t {
+		case "musl":
+			libc = "musl"
+		case "static":
+			static = true
+		case "gnu", "glibc":
+			libc = "glibc"
+		}
+	}
+	format := detectAssetFormat(a.name)
+	if format == formatBinary && strings.HasSuffix(strings.ToLower(a.name), ".exe") {
+		oses["windows"] = true
+	}
+
+	switch {
+	case oses[p.os]:
+	case len(oses) == 0:
+		r.reject("no OS in the name")
+	default:
+		r.reject("OS mismatch")
+	}
+
+	switch {
+	case arches[p.arch]:
+		if amd64Variant {
+			r.adjust(-5, "requires a newer CPU than the baseline")
+		}
+	case universal && p.os == "darwin":
+		r.adjust(-5, "universal binary")
+	case len(arches) == 0:
+		r.reject("no architecture in the name")
+	default:
+		r.reject("architecture mismatch")
+	}
+
+	switch format {
+	case formatArchive:
+		r.adjust(30, "archive")
+	case formatCompressed:
+		r.adjust(20, "compressed file")
+	case formatBinary:
+		r.adjust(10, "binary")
+	case formatPackage:
+		r.reject("unsupported format")
+	case formatMetadata:
+		r.reject("checksum, signature or SBOM file")
+	}
+
+	if p.os == "linux" && p.libc != "" {
+		switch {
+		case static:
+			r.adjust(5, "statically linked")
+		case libc == "":
+		case libc == p.libc:
+			r.adjust(10, "built for "+libc)
+		case libc == "musl":
+			// musl builds are usually linked statically and run anywhere.
+			r.adjust(-5, "built for musl")
+		default:
+			r.reject("built for " + libc)
+		}
+	}
+
+	return r
+}
+
+// rankAssets ranks every asset for p, the best first. Rejected assets come
+// last, and assets of the same score keep their order.
+func rankAssets(p platform, assets []releaseAsset) []*assetRanking {
+	rankings := make([]*assetRanking, 0, len(assets))
+	for _, a := range assets {
+		rankings = append(rankings, rankAsset(p, a))
+	}
+	sort.SliceStable(rankings, func(i, j int) bool {
+		if rankings[i].rejected() != rankings[j].rejected() {
+			return !rankings[i].rejected()
+		}
+		return rankings[i].score > rankings[j].score
+	})
+	return rankings
+}
+
+// findCompatibleAsset returns the asset ranked best for p.
+func findCompatibleAsset(p platform, assets []releaseAsset) (releaseAsset, bool) {
+	rankings := rankAssets(p, assets)
+	if len(rankings) == 0 || rankings[0].rejected() {
+		return releaseAsset{}, false
+	}
+	return rankings[0].asset, true
+}
@@ -0,0 +1,125 @@
+package gpkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindCompatibleAsset(t *testing.T) {
+	ripgrep := []string{
+		"ripgrep-13.0.0-arm-unknown-linux-gnueabihf.tar.gz",
+		"ripgrep-13.0.0-i686-pc-windows-msvc.zip",
+		"ripgrep-13.0.0-x86_64-apple-darwin.tar.gz",
+		"ripgrep-13.0.0-x86_64-pc-windows-msvc.zip",
+		"ripgrep-13.0.0-x86_64-unknown-linux-musl.tar.gz",
+		"ripgrep-13.0.0-x86_64-unknown-linux-musl.tar.gz.sha256",
+		"ripgrep_13.0.0_amd64.deb",
+	}
+	tool := []string{
+		"checksums.txt",
+		"tool_1.0.0_linux_amd64.deb",
+		"tool_1.0.0_linux_amd64.rpm",
+		"tool_1.0.0_linux_amd64.sbom.json",
+		"tool_1.0.0_linux_amd64v3.tar.gz",
+		"tool_1.0.0_linux_amd64.tar.gz",
+		"tool_1.0.0_linux_amd64.tar.gz.sig",
+		"tool_1.0.0_linux_aarch64.tar.gz",
+		"tool_1.0.0_linux_i386.tar.gz",
+		"tool_1.0.0_macOS_universal.tar.gz",
+	}
+	libc := []string{
+		"foo-linux-x86_64-musl.tar.gz",
+		"foo-linux-x86_64-gnu.tar.gz",
+	}
+	formats := []string{
+		"foo-linux-amd64",
+		"foo-linux-amd64.gz",
+		"foo-linux-amd64.tar.gz",
+	}
+
+	tests := []struct {
+		name     string
+		platform platform
+		assets   []string
+		expected string
+	}{
+		{"musl is the only linux build", platform{"linux", "amd64", "glibc"}, ripgrep, "ripgrep-13.0.0-x86_64-unknown-linux-musl.tar.gz"},
+		{"x86 is not taken for x86_64", platform{"windows", "386", ""}, ripgrep, "ripgrep-13.0.0-i686-pc-windows-msvc.zip"},
+		{"apple darwin", platform{"darwin", "amd64", ""}, ripgrep, "ripgrep-13.0.0-x86_64-apple-darwin.tar.gz"},
+		{"gnueabihf", platform{"linux", "arm", "glibc"}, ripgrep, "ripgrep-13.0.0-arm-unknown-linux-gnueabihf.tar.gz"},
+		{"no package", platform{"linux", "arm64", ""}, ripgrep, ""},
+		{"baseline over amd64v3", platform{"linux", "amd64", ""}, tool, "tool_1.0.0_linux_amd64.tar.gz"},
+		{"aarch64", platform{"linux", "arm64", ""}, tool, "tool_1.0.0_linux_aarch64.tar.gz"},
+		{"i386", platform{"linux", "386", ""}, tool, "tool_1.0.0_linux_i386.tar.gz"},
+		{"universal", platform{"darwin", "arm64", ""}, tool, "tool_1.0.0_macOS_universal.tar.gz"},
+		{"universal only on darwin", platform{"windows", "amd64", ""}, tool, ""},
+		{"glibc host", platform{"linux", "amd64", "glibc"}, libc, "foo-linux-x86_64-gnu.tar.gz"},
+		{"musl host", platform{"linux", "amd64", "musl"}, libc, "foo-linux-x86_64-musl.tar.gz"},
+		{"glibc builds are rejected on musl", platform{"linux", "amd64", "musl"}, libc[1:], ""},
+		{"unknown libc keeps the order", platform{"linux", "amd64", ""}, libc, "foo-linux-x86_64-musl.tar.gz"},
+		{"archive over compressed file and binary", platform{"linux", "amd64", ""}, formats, "foo-linux-amd64.tar.gz"},
+		{"compressed file over binary", platform{"linux", "amd64", ""}, formats[:2], "foo-linux-amd64.gz"},
+		{"linux in another word", platform{"linux", "amd64", ""}, []string{"linuxbrew-amd64.tar.gz"}, ""},
+		{"exe", platform{"windows", "amd64", ""}, []string{"foo-x64.exe"}, "foo-x64.exe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var assets []releaseAsset
+			for _, name := range tt.assets {
+				assets = append(assets, releaseAsset{name: name})
+			}
+			got, ok := findCompatibleAsset(tt.platform, assets)
+			assert.Equal(t, tt.expected != "", ok)
+			assert.Equal(t, tt.expected, got.name)
+		})
+	}
+}
+
+func TestRankAsset_Reasons(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected []string
+	}{
+		{"foo-darwin-amd64.tar.gz", []string{"OS mismatch"}},
+		{"foo-linux-arm64.tar.gz", []string{"architecture mismatch"}},
+		{"foo-linux-amd64.deb", []string{"unsupported format"}},
+		{"foo-linux-amd64.tar.gz.sha256", []string{"checksum, signature or SBOM file"}},
+		{"foo.tar.gz", []string{"no OS in the name", "no architecture in the name"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := rankAsset(platform{os: "linux", arch: "amd64"}, releaseAsset{name: tt.name})
+			assert.True(t, r.rejected())
+			assert.Equal(t, tt.expected, r.rejections)
+		})
+	}
+}
+
+func TestDetectAssetFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected assetFormat
+	}{
+		{"foo.tar.gz", formatArchive},
+		{"foo.TGZ", formatArchive},
+		{"foo.zip", formatArchive},
+		{"foo.gz", formatCompressed},
+		{"foo.zst", formatCompressed},
+		{"foo", formatBinary},
+		{"foo-1.2.3-linux-amd64", formatBinary},
+		{"foo.exe", formatBinary},
+		{"foo.deb", formatPackage},
+		{"foo.rpm", formatPackage},
+		{"foo.tar.gz.sha256", formatMetadata},
+		{"foo.tar.gz.asc", formatMetadata},
+		{"SHA256SUMS", formatMetadata},
+		{"foo.sbom", formatMetadata},
+		{"foo-sbom.spdx.json", formatMetadata},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, detectAssetFormat(tt.name))
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package gpkg
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// semver is a semantic version parsed from a tag. Components left out, as in
+// v1.2, are zero.
+type semver struct {
+	major, minor, patch uint64
+	pre                 []string
+}
+
+var reSemver = regexp.MustCompile(`^[vV]?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// parseSemver parses a tag such as v1.2.3 or 1.2.3-rc.1 into a version, and
+// returns how many of major, minor and patch were given.
+func parseSemver(s string) (*semver, int, error) {
+	m := reSemver.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return nil, 0, fmt.Errorf("invalid version %q", s)
+	}
+	v := &semver{}
+	parts := 0
+	for i, p := range []*uint64{&v.major, &v.minor, &v.patch} {
+		if m[i+1] == "" {
+			break
+		}
+		n, err := strconv.ParseUint(m[i+1], 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid version %q", s)
+		}
+		*p = n
+		parts++
+	}
+	if m[4] != "" {
+		v.pre = strings.Split(m[4], ".")
+	}
+	return v, parts, nil
+}
+
+func (v *semver) isPrerelease() bool {
+	return len(v.pre) > 0
+}
+
+// isPrereleaseTag reports whether tag is a prerelease version, for hosts which
+// do not mark prereleases themselves. A tag which is not a version is not.
+func isPrereleaseTag(tag string) bool {
+	v, _, err := parseSemver(tag)
+	return err == nil && v.isPrerelease()
+}
+
+// compare returns -1, 0 or 1 as v is lower than, equal to or higher than w,
+// following the precedence rules of Semantic Versioning.
+func (v *semver) compare(w *semver) int {
+	for _, d := range [][2]uint64{{v.major, w.major}, {v.minor, w.minor}, {v.patch, w.patch}} {
+		if d[0] != d[1] {
+			if d[0] < d[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	// A prerelease is lower than the release itself.
+	switch {
+	case len(v.pre) == 0 && len(w.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(w.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(w.pre); i++ {
+		if c := comparePrereleaseIdentifier(v.pre[i], w.pre[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(v.pre) < len(w.pre):
+		return -1
+	case len(v.pre) > len(w.pre):
+		return 1
+	}
+	return 0
+}
+
+// comparePrereleaseIdentifier compares numeric identifiers numerically, and
+// others in ASCII order. Numeric identifiers are lower than the others.
+func comparePrereleaseIdentifier(a, b string) int {
+	an, aerr := strconv.ParseUint(a, 10, 64)
+	bn, berr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aerr == nil && berr == nil:
+		if an == bn {
+			return 0
+		}
+		if an < bn {
+			return -1
+		}
+		return 1
+	case aerr == nil:
+		return -1
+	case berr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// versionRange is the versions from lower up to, but not including, upper. A
+// nil bound is unlimited, and negate turns the range inside out.
+type versionRange struct {
+	lower, upper *semver
+	lowerOpen    bool
+	negate       bool
+}
+
+func (r *versionRange) contains(v *semver) bool {
+	in := true
+	if r.lower != nil {
+		c := v.compare(r.lower)
+		in = c > 0 || (c == 0 && !r.lowerOpen)
+	}
+	if in && r.upper != nil {
+		// Prereleases of the upper bound are excluded along with it, so that
+		// <1.5.0 does not reach 1.5.0-rc.1.
+		w := v
+		if !r.upper.isPrerelease() {
+			w = &semver{major: v.major, minor: v.minor, patch: v.patch}
+		}
+		in = w.compare(r.upper) < 0
+	}
+	return in != r.negate
+}
+
+// versionConstraint is a set of version ranges such as "~1.4", "^2" or
+// ">=0.30, <0.40". Comma-separated ranges must all be satisfied, and either
+// side of || may be.
+type versionConstraint struct {
+	groups [][]*versionRange
+}
+
+var reConstraintTerm = regexp.MustCompile(`^(=|!=|>=|<=|>|<|~|\^)?\s*(\S+)$`)
+
+// reWildcardVersion matches a version with wildcard components, such as 1.2.x.
+var reWildcardVersion = regexp.MustCompile(`^[vV]?\d+(\.\d+)*(\.[xX*])+$`)
+
+// isVersionConstraint reports whether ref is a constraint rather than a tag.
+// None of the operators can be part of a git tag.
+func isVersionConstraint(ref string) bool {
+	return strings.ContainsAny(ref, "~^<>*|") || strings.HasPrefix(ref, "=") || strings.HasPrefix(ref, "!=") || reWildcardVersion.MatchString(ref)
+}
+
+// isFloatingRef reports whether ref may resolve to another release over time.
+func isFloatingRef(ref string) bool {
+	return ref == "" || ref == "latest" || ref == "newest" || isVersionConstraint(ref)
+}
+
+func parseVersionConstraint(s string) (*versionConstraint, error) {
+	c := &versionConstraint{}
+	for _, alt := range strings.Split(s, "||") {
+		var group []*versionRange
+		for _, term := range strings.Split(alt, ",") {
+			r, err := parseVersionRange(strings.TrimSpace(term))
+			if err != nil {
+				return nil, fmt.Errorf("invalid version constraint %q: %s", s, err)
+			}
+			group = append(group, r)
+		}
+		c.groups = append(c.groups, group)
+	}
+	return c, nil
+}
+
+func parseVersionRange(term string) (*versionRange, error) {
+	if term == "*" {
+		return &versionRange{}, nil
+	}
+	m := reConstraintTerm.FindStringSubmatch(term)
+	if m == nil {
+		return nil, fmt.Errorf("invalid term %q", term)
+	}
+	op, ver := m[1], m[2]
+
+	// A wildcard stands for the components left out, as in 1.2.* or 1.x.
+	for _, w := range []string{".*", ".x", ".X"} {
+		for strings.HasSuffix(ver, w) {
+			ver = strings.TrimSuffix(ver, w)
+		}
+	}
+	v, parts, err := parseSemver(ver)
+	if err != nil {
+		return nil, err
+	}
+
+	// next is the lowest version past the ones matching the components given.
+	next := &semver{major: v.major + 1}
+	switch {
+	case parts == 3 && v.isPrerelease():
+		// An additional identifier makes the least higher prerelease.
+		pre := append(append([]string{}, v.pre...), "0")
+		next = &semver{major: v.major, minor: v.minor, patch: v.patch, pre: pre}
+	case parts == 3:
+		next = &semver{major: v.major, minor: v.minor, patch: v.patch + 1, pre: []string{"0"}}
+	case parts == 2:
+		next = &semver{major: v.major, minor: v.minor + 1}
+	}
+
+	switch op {
+	case "", "=":
+		return &versionRange{lower: v, upper: next}, nil
+	case "!=":
+		return &versionRange{lower: v, upper: next, negate: true}, nil
+	case ">":
+		if parts == 3 {
+			return &versionRange{lower: v, lowerOpen: true}, nil
+		}
+		return &versionRange{lower: next}, nil
+	case ">=":
+		return &versionRange{lower: v}, nil
+	case "<":
+		return &versionRange{upper: v}, nil
+	case "<=":
+		return &versionRange{upper: next}, nil
+	case "~":
+		// Patch updates if a minor version is given, minor ones otherwise.
+		if parts >= 2 {
+			return &versionRange{lower: v, upper: &semver{major: v.major, minor: v.minor + 1}}, nil
+		}
+		return &versionRange{lower: v, upper: &semver{major: v.major + 1}}, nil
+	case "^":
+		// Updates not changing the leftmost non-zero component.
+		switch {
+		case v.major > 0 || parts == 1:
+			return &versionRange{lower: v, upper: &semver{major: v.major + 1}}, nil
+		case v.minor > 0 || parts == 2:
+			return &versionRange{lower: v, upper: &semver{minor: v.minor + 1}}, nil
+		}
+		return &versionRange{lower: v, upper: &semver{patch: v.patch + 1}}, nil
+	}
+	return nil, fmt.Errorf("invalid term %q", term)
+}
+
+// check reports whether v satisfies c. Prereleases never do unless
+// prerelease is true.
+func (c *versionConstraint) check(v *semver, prerelease bool) bool {
+	if v.isPrerelease() && !prerelease {
+		return false
+	}
+	for _, group := range c.groups {
+		ok := true
+		for _, r := range group {
+			if !r.contains(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pick returns the index of the highest tag satisfying c, or -1 if there is
+// none. Tags which are not versions are ignored.
+func (c *versionConstraint) pick(tags []string, prerelease bool) int {
+	found := -1
+	var best *semver
+	for i, tag := range tags {
+		v, _, err := parseSemver(tag)
+		if err != nil || !c.check(v, prerelease) {
+			continue
+		}
+		if best == nil || v.compare(best) > 0 {
+			found = i
+			best = v
+		}
+	}
+	return found
+}
+
+// pickVersion returns the index of the highest tag satisfying the constraint,
+// ignoring tags which are not versions. Prereleases are ignored unless
+// prerelease is true.
+func pickVersion(constraint string, tags []string, prerelease bool) (int, error) {
+	c, err := parseVersionConstraint(constraint)
+	if err != nil {
+		return -1, err
+	}
+	i := c.pick(tags, prerelease)
+	if i < 0 {
+		return -1, fmt.Errorf("No release satisfies %s", constraint)
+	}
+	return i, nil
+}
@@ -0,0 +1,202 @@
+package gpkg
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSemver(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected *semver
+		parts    int
+		recvErr  bool
+	}{
+		{"1.2.3", &semver{major: 1, minor: 2, patch: 3}, 3, false},
+		{"v1.2.3", &semver{major: 1, minor: 2, patch: 3}, 3, false},
+		{"v0.30", &semver{minor: 30}, 2, false},
+		{"2", &semver{major: 2}, 1, false},
+		{"v1.0.0-rc.1+build.5", &semver{major: 1, pre: []string{"rc", "1"}}, 3, false},
+		{"nightly", nil, 0, true},
+		{"release-1.2.3", nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, parts, err := parseSemver(tt.input)
+			if tt.recvErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+			assert.Equal(t, tt.parts, parts)
+		})
+	}
+}
+
+func TestSemver_Compare(t *testing.T) {
+	// In ascending order
+	versions := []string{
+		"0.9.9",
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.2.0",
+		"1.10.0",
+		"2.0.0",
+	}
+	for i := 0; i < len(versions)-1; i++ {
+		v, _, err := parseSemver(versions[i])
+		require.NoError(t, err)
+		w, _, err := parseSemver(versions[i+1])
+		require.NoError(t, err)
+		assert.Equal(t, -1, v.compare(w), "%s < %s", versions[i], versions[i+1])
+		assert.Equal(t, 1, w.compare(v), "%s > %s", versions[i+1], versions[i])
+		assert.Equal(t, 0, v.compare(v))
+	}
+}
+
+func TestVersionConstraint_Check(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{"~1.4", "v1.4.0", true},
+		{"~1.4", "v1.4.9", true},
+		{"~1.4", "v1.5.0", false},
+		{"~1.4.2", "v1.4.1", false},
+		{"~1", "v1.9.0", true},
+		{"^2", "v2.3.4", true},
+		{"^2", "v3.0.0", false},
+		{"^0.3", "v0.3.5", true},
+		{"^0.3", "v0.4.0", false},
+		{"^0.0.3", "v0.0.4", false},
+		{">=0.30, <0.40", "v0.35.1", true},
+		{">=0.30, <0.40", "v0.40.0", false},
+		{">=0.30, <0.40", "v0.29.9", false},
+		{">1.2", "v1.2.9", false},
+		{">1.2", "v1.3.0", true},
+		{">1.2.3", "v1.2.4", true},
+		{"<=1.2", "v1.2.9", true},
+		{"<=1.2", "v1.3.0", false},
+		{"1.2.x", "v1.2.7", true},
+		{"1.2.*", "v1.3.0", false},
+		{"=1.2.3", "v1.2.3", true},
+		{"=1.2.3", "v1.2.4", false},
+		{"!=1.2.3", "v1.2.4", true},
+		{"^1 || ^3", "v3.1.0", true},
+		{"^1 || ^3", "v2.1.0", false},
+		{"*", "v9.9.9", true},
+		{"^1", "v1.1.0-rc.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.constraint+" "+tt.version, func(t *testing.T) {
+			c, err := parseVersionConstraint(tt.constraint)
+			require.NoError(t, err)
+			v, _, err := parseSemver(tt.version)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, c.check(v, false))
+		})
+	}
+}
+
+func TestVersionConstraint_Check_Prerelease(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{"^1", "v1.1.0-rc.1", true},
+		{"~1.4", "v1.5.0-rc.1", false},
+		{"<1.5.0", "v1.5.0-rc.1", false},
+		{">=2.0.0-rc.1", "v2.0.0-rc.2", true},
+		{"=2.0.0-rc.1", "v2.0.0-rc.2", false},
+		{"=2.0.0-rc.1", "v2.0.0-rc.1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.constraint+" "+tt.version, func(t *testing.T) {
+			c, err := parseVersionConstraint(tt.constraint)
+			require.NoError(t, err)
+			v, _, err := parseSemver(tt.version)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, c.check(v, true))
+		})
+	}
+}
+
+func TestParseVersionConstraint_Invalid(t *testing.T) {
+	for _, s := range []string{"~foo", ">=1.0,", ">> 1", "^1 ||"} {
+		t.Run(s, func(t *testing.T) {
+			_, err := parseVersionConstraint(s)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestPickVersion(t *testing.T) {
+	tags := []string{"nightly", "v1.4.1", "v1.5.0-rc.1", "v1.4.10", "v1.4.2", "v2.0.0"}
+	tests := []struct {
+		constraint string
+		prerelease bool
+		expected   int
+		recvErr    bool
+	}{
+		{"~1.4", false, 3, false},
+		{"^1", false, 3, false},
+		{"^1", true, 2, false},
+		{"^2", false, 5, false},
+		{"^3", false, -1, true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s prerelease=%t", tt.constraint, tt.prerelease), func(t *testing.T) {
+			got, err := pickVersion(tt.constraint, tags, tt.prerelease)
+			if tt.recvErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestIsVersionConstraint(t *testing.T) {
+	tests := []struct {
+		ref      string
+		expected bool
+	}{
+		{"~1.4", true},
+		{"^2", true},
+		{">=0.30, <0.40", true},
+		{"=1.2.3", true},
+		{"1.2.*", true},
+		{"1.2.x", true},
+		{"v1.X", true},
+		{"x", false},
+		{"1.2.x-rc", false},
+		{"v1.2.3", false},
+		{"latest", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			assert.Equal(t, tt.expected, isVersionConstraint(tt.ref))
+		})
+	}
+}
+
+func TestIsPrereleaseTag(t *testing.T) {
+	assert.True(t, isPrereleaseTag("v2.0.0-rc.1"))
+	assert.True(t, isPrereleaseTag("1.0.0-beta"))
+	assert.False(t, isPrereleaseTag("v2.0.0"))
+	assert.False(t, isPrereleaseTag("nightly"))
+}
@@ -0,0 +1,335 @@
+package gpkg
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	VerifyMinisign = "minisign"
+	VerifyCosign   = "cosign"
+	VerifyPGP      = "pgp"
+)
+
+// defaultSignatureNames are the names signatures are published with by each
+// tool, as templates receiving the asset name.
+var defaultSignatureNames = map[string]string{
+	VerifyMinisign: "{{.Asset}}.minisig",
+	VerifyCosign:   "{{.Asset}}.sig",
+	VerifyPGP:      "{{.Asset}}.asc",
+}
+
+// VerifySpec describes how the signature of an asset is verified. public_key
+// is either the key itself or the path to a file holding it. signature and
+// certificate are names or URLs relative to the asset, as templates receiving
+// .Asset.
+type VerifySpec struct {
+	Type        string `json:"type"`
+	PublicKey   string `json:"public_key"`
+	Signature   string `json:"signature,omitempty"`
+	Certificate string `json:"certificate,omitempty"`
+	Identity    string `json:"identity,omitempty"`
+}
+
+func (v *VerifySpec) Validate() error {
+	switch v.Type {
+	case VerifyMinisign, VerifyPGP:
+	case VerifyCosign:
+		if v.Certificate != "" && v.Identity == "" {
+			return errors.New("verify.identity is required with verify.certificate.")
+		}
+	default:
+		return fmt.Errorf("verify.type must be one of %s, %s or %s.", VerifyMinisign, VerifyCosign, VerifyPGP)
+	}
+	if v.PublicKey == "" {
+		return errors.New("verify.public_key is required.")
+	}
+	return nil
+}
+
+// renderName expands a signature or certificate name for the asset.
+func (v *VerifySpec) renderName(text, asset string) (string, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, struct{ Asset string }{asset}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// publicKey returns the key given inline, or read from the file it names.
+func (v *VerifySpec) publicKey() ([]byte, error) {
+	if strings.Contains(v.PublicKey, "-----BEGIN") {
+		return []byte(v.PublicKey), nil
+	}
+	if v.Type == VerifyMinisign {
+		if _, _, err := parseMinisignPublicKey([]byte(v.PublicKey)); err == nil {
+			return []byte(v.PublicKey), nil
+		}
+	}
+	return os.ReadFile(expandPath(v.PublicKey))
+}
+
+// verifySignature verifies the asset read from r against the signature
+// published next to it.
+func verifySignature(v *VerifySpec, dl Downloader, r io.Reader) error {
+	name := dl.GetAssetName()
+	rf, ok := dl.(relatedFetcher)
+	if !ok {
+		return fmt.Errorf("signatures cannot be fetched for %s", name)
+	}
+
+	key, err := v.publicKey()
+	if err != nil {
+		return fmt.Errorf("failed to read the public key: %s", err)
+	}
+
+	sigName := v.Signature
+	if sigName == "" {
+		sigName = defaultSignatureNames[v.Type]
+	}
+	if sigName, err = v.renderName(sigName, name); err != nil {
+		return err
+	}
+	sig, err := rf.fetchRelated(sigName)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the signature of %s: %s", name, err)
+	}
+
+	switch v.Type {
+	case VerifyMinisign:
+		err = verifyMinisign(key, sig, r)
+	case VerifyCosign:
+		var cert []byte
+		if v.Certificate != "" {
+			certName, err := v.renderName(v.Certificate, name)
+			if err != nil {
+				return err
+			}
+			if cert, err = rf.fetchRelated(certName); err != nil {
+				return fmt.Errorf("failed to fetch the certificate of %s: %s", name, err)
+			}
+		}
+		err = verifyCosign(key, sig, cert, v.Identity, r)
+	case VerifyPGP:
+		err = verifyPGP(key, sig, r)
+	default:
+		err = fmt.Errorf("unknown signature type: %s", v.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to verify the signature of %s: %s", name, err)
+	}
+	return nil
+}
+
+// minisignLines returns the lines of a minisign key or signature file.
+func minisignLines(data []byte) []string {
+	var lines []string
+	for _, l := range strings.Split(string(data), "\n") {
+		if l = strings.TrimRight(l, "\r"); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// parseMinisignPublicKey parses a minisign public key, with or without the
+// untrusted comment line of the file it is saved in.
+func parseMinisignPublicKey(data []byte) ([]byte, ed25519.PublicKey, error) {
+	lines := minisignLines(data)
+	if len(lines) == 0 {
+		return nil, nil, errors.New("empty minisign public key")
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[len(lines)-1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid minisign public key: %s", err)
+	}
+	if len(raw) != 2+8+ed25519.PublicKeySize || string(raw[:2]) != "Ed" {
+		return nil, nil, errors.New("invalid minisign public key")
+	}
+	return raw[2:10], ed25519.PublicKey(raw[10:]), nil
+}
+
+// maxLegacyMinisignSize is the largest file verified against a legacy
+// minisign signature, which signs the file itself and so needs it in memory.
+var maxLegacyMinisignSize int64 = 64 << 20
+
+// verifyMinisign verifies a minisign signature, either of the legacy format
+// signing the file itself or of the one signing its BLAKE2b-512 digest, along
+// with the trusted comment.
+func verifyMinisign(key, sig []byte, r io.Reader) error {
+	keyID, pk, err := parseMinisignPublicKey(key)
+	if err != nil {
+		return err
+	}
+
+	lines := minisignLines(sig)
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return errors.New("invalid minisign signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(raw) != 2+8+ed25519.SignatureSize {
+		return errors.New("invalid minisign signature")
+	}
+	alg, sigKeyID, s := string(raw[:2]), raw[2:10], raw[10:]
+	if !bytes.Equal(keyID, sigKeyID) {
+		return fmt.Errorf("signed with another key. key_id=%X", sigKeyID)
+	}
+
+	var msg []byte
+	switch alg {
+	case "Ed":
+		msg, err = io.ReadAll(io.LimitReader(r, maxLegacyMinisignSize+1))
+		if err == nil && int64(len(msg)) > maxLegacyMinisignSize {
+			return fmt.Errorf("a legacy minisign signature is only verified for files up to %d MiB, unlike a prehashed one", maxLegacyMinisignSize>>20)
+		}
+	case "ED":
+		h, _ := blake2b.New512(nil)
+		_, err = io.Copy(h, r)
+		msg = h.Sum(nil)
+	default:
+		return fmt.Errorf("unsupported minisign algorithm: %s", alg)
+	}
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pk, msg, s) {
+		return errors.New("invalid signature")
+	}
+
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil {
+		return errors.New("invalid minisign signature")
+	}
+	comment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	if !ed25519.Verify(pk, append(s, comment...), global) {
+		return errors.New("invalid trusted comment")
+	}
+	return nil
+}
+
+// verifyCosign verifies a signature made by `cosign sign-blob`. The signature
+// is checked with the PEM public key, or with the key of the certificate when
+// given, in which case the certificate must be issued for identity by one of
+// the PEM certificates in key. The transparency log is not consulted.
+func verifyCosign(key, sig, cert []byte, identity string, r io.Reader) error {
+	s, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return fmt.Errorf("invalid cosign signature: %s", err)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	digest := h.Sum(nil)
+
+	var pub crypto.PublicKey
+	if cert == nil {
+		block, _ := pem.Decode(key)
+		if block == nil {
+			return errors.New("invalid public key")
+		}
+		if pub, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return fmt.Errorf("invalid public key: %s", err)
+		}
+	} else {
+		c, err := verifyCosignCertificate(key, cert, identity)
+		if err != nil {
+			return err
+		}
+		pub = c.PublicKey
+	}
+
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest, s) {
+			return errors.New("invalid signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, s); err != nil {
+			return errors.New("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported key type: %T", pub)
+	}
+	return nil
+}
+
+// verifyCosignCertificate parses the certificate written by cosign, which is
+// base64-encoded PEM, and checks that it is issued for identity by roots.
+func verifyCosignCertificate(roots, cert []byte, identity string) (*x509.Certificate, error) {
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(cert))); err == nil {
+		cert = decoded
+	}
+	block, _ := pem.Decode(cert)
+	if block == nil {
+		return nil, errors.New("invalid certificate")
+	}
+	c, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %s", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(roots) {
+		return nil, errors.New("no certificate found in the public key")
+	}
+	// Certificates for keyless signing expire minutes after being issued, so
+	// the chain is verified as of the issuance.
+	if _, err := c.Verify(x509.VerifyOptions{
+		Roots:       pool,
+		CurrentTime: c.NotBefore,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return nil, fmt.Errorf("untrusted certificate: %s", err)
+	}
+
+	for _, e := range c.EmailAddresses {
+		if e == identity {
+			return c, nil
+		}
+	}
+	for _, u := range c.URIs {
+		if u.String() == identity {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("certificate is not issued for %s", identity)
+}
+
+// verifyPGP verifies a detached OpenPGP signature, either armored or binary,
+// against the keys in key.
+func verifyPGP(key, sig []byte, r io.Reader) error {
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(key))
+	if err != nil {
+		if keyring, err = openpgp.ReadKeyRing(bytes.NewReader(key)); err != nil {
+			return fmt.Errorf("invalid keyring: %s", err)
+		}
+	}
+
+	if bytes.Contains(sig, []byte("-----BEGIN PGP SIGNATURE-----")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(keyring, r, bytes.NewReader(sig), nil)
+	} else {
+		_, err = openpgp.CheckDetachedSignature(keyring, r, bytes.NewReader(sig), nil)
+	}
+	return err
+}
@@ -0,0 +1,346 @@
+package gpkg
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
+)
+
+type testMinisignKey struct {
+	id   []byte
+	priv ed25519.PrivateKey
+}
+
+func newTestMinisignKey(t *testing.T) *testMinisignKey {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	id := make([]byte, 8)
+	_, err = rand.Read(id)
+	require.NoError(t, err)
+	return &testMinisignKey{id: id, priv: priv}
+}
+
+func (k *testMinisignKey) publicKey() string {
+	raw := append([]byte("Ed"), k.id...)
+	raw = append(raw, k.priv.Public().(ed25519.PublicKey)...)
+	return "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(raw) + "\n"
+}
+
+// sign signs data in the legacy format with alg Ed, or its BLAKE2b-512 digest
+// with alg ED.
+func (k *testMinisignKey) sign(alg string, data []byte) []byte {
+	msg := data
+	if alg == "ED" {
+		h := blake2b.Sum512(data)
+		msg = h[:]
+	}
+	s := ed25519.Sign(k.priv, msg)
+	raw := append([]byte(alg), k.id...)
+	raw = append(raw, s...)
+
+	comment := "timestamp:1690000000\tfile:foo"
+	global := ed25519.Sign(k.priv, append(s, comment...))
+	return []byte(fmt.Sprintf("untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(raw), comment, base64.StdEncoding.EncodeToString(global)))
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	data := []byte("foo")
+	key := newTestMinisignKey(t)
+	other := newTestMinisignKey(t)
+
+	tests := []struct {
+		name    string
+		key     string
+		sig     []byte
+		data    []byte
+		recvErr bool
+	}{
+		{"prehashed", key.publicKey(), key.sign("ED", data), data, false},
+		{"legacy", key.publicKey(), key.sign("Ed", data), data, false},
+		{"key without comment", minisignLines([]byte(key.publicKey()))[1], key.sign("ED", data), data, false},
+		{"tampered data", key.publicKey(), key.sign("ED", data), []byte("bar"), true},
+		{"another key", other.publicKey(), key.sign("ED", data), data, true},
+		{"tampered comment", key.publicKey(), bytes.Replace(key.sign("ED", data), []byte("file:foo"), []byte("file:bar"), 1), data, true},
+		{"malformed signature", key.publicKey(), []byte("foo"), data, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyMinisign([]byte(tt.key), tt.sig, bytes.NewReader(tt.data))
+			if tt.recvErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	t.Run("legacy signature of a large file", func(t *testing.T) {
+		defer func(n int64) { maxLegacyMinisignSize = n }(maxLegacyMinisignSize)
+		maxLegacyMinisignSize = 2
+		require.Error(t, verifyMinisign([]byte(key.publicKey()), key.sign("Ed", data), bytes.NewReader(data)))
+		require.NoError(t, verifyMinisign([]byte(key.publicKey()), key.sign("ED", data), bytes.NewReader(data)))
+	})
+}
+
+func newTestECDSAKey(t *testing.T) *ecdsa.PrivateKey {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return priv
+}
+
+func cosignSign(t *testing.T, priv *ecdsa.PrivateKey, data []byte) []byte {
+	digest := sha256.Sum256(data)
+	s, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+	require.NoError(t, err)
+	return []byte(base64.StdEncoding.EncodeToString(s))
+}
+
+func encodePublicKeyPEM(t *testing.T, priv *ecdsa.PrivateKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(priv.Public())
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// newTestCertificate issues a certificate for the key of priv, which expired
+// long ago like the ones for keyless signing, and returns it along with the
+// certificate of the issuer, both in PEM.
+func newTestCertificate(t *testing.T, priv *ecdsa.PrivateKey, email string) (cert, root []byte) {
+	caKey := newTestECDSAKey(t)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test root"},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(-time.Hour + 10*time.Minute),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		EmailAddresses: []string{email},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, priv.Public(), caKey)
+	require.NoError(t, err)
+
+	cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	root = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	return cert, root
+}
+
+func TestVerifyCosign(t *testing.T) {
+	data := []byte("foo")
+	priv := newTestECDSAKey(t)
+	other := newTestECDSAKey(t)
+
+	t.Run("public key", func(t *testing.T) {
+		require.NoError(t, verifyCosign(encodePublicKeyPEM(t, priv), cosignSign(t, priv, data), nil, "", bytes.NewReader(data)))
+	})
+	t.Run("another key", func(t *testing.T) {
+		require.Error(t, verifyCosign(encodePublicKeyPEM(t, other), cosignSign(t, priv, data), nil, "", bytes.NewReader(data)))
+	})
+	t.Run("tampered data", func(t *testing.T) {
+		require.Error(t, verifyCosign(encodePublicKeyPEM(t, priv), cosignSign(t, priv, data), nil, "", bytes.NewReader([]byte("bar"))))
+	})
+
+	cert, root := newTestCertificate(t, priv, "release@example.com")
+	certBlob := []byte(base64.StdEncoding.EncodeToString(cert))
+	t.Run("certificate", func(t *testing.T) {
+		require.NoError(t, verifyCosign(root, cosignSign(t, priv, data), certBlob, "release@example.com", bytes.NewReader(data)))
+	})
+	t.Run("certificate in PEM", func(t *testing.T) {
+		require.NoError(t, verifyCosign(root, cosignSign(t, priv, data), cert, "release@example.com", bytes.NewReader(data)))
+	})
+	t.Run("another identity", func(t *testing.T) {
+		require.Error(t, verifyCosign(root, cosignSign(t, priv, data), certBlob, "attacker@example.com", bytes.NewReader(data)))
+	})
+	t.Run("untrusted certificate", func(t *testing.T) {
+		_, otherRoot := newTestCertificate(t, priv, "release@example.com")
+		require.Error(t, verifyCosign(otherRoot, cosignSign(t, priv, data), certBlob, "release@example.com", bytes.NewReader(data)))
+	})
+	t.Run("signed with another key", func(t *testing.T) {
+		require.Error(t, verifyCosign(root, cosignSign(t, other, data), certBlob, "release@example.com", bytes.NewReader(data)))
+	})
+}
+
+func newTestPGPEntity(t *testing.T) (*openpgp.Entity, []byte) {
+	e, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, e.Serialize(w))
+	require.NoError(t, w.Close())
+	return e, buf.Bytes()
+}
+
+func TestVerifyPGP(t *testing.T) {
+	data := []byte("foo")
+	e, keyring := newTestPGPEntity(t)
+	_, otherKeyring := newTestPGPEntity(t)
+
+	var armored, binary bytes.Buffer
+	require.NoError(t, openpgp.ArmoredDetachSign(&armored, e, bytes.NewReader(data), nil))
+	require.NoError(t, openpgp.DetachSign(&binary, e, bytes.NewReader(data), nil))
+
+	tests := []struct {
+		name    string
+		keyring []byte
+		sig     []byte
+		data    []byte
+		recvErr bool
+	}{
+		{"armored", keyring, armored.Bytes(), data, false},
+		{"binary", keyring, binary.Bytes(), data, false},
+		{"tampered data", keyring, armored.Bytes(), []byte("bar"), true},
+		{"another keyring", otherKeyring, armored.Bytes(), data, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyPGP(tt.keyring, tt.sig, bytes.NewReader(tt.data))
+			if tt.recvErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	dir := t.TempDir()
+	asset := filepath.Join(dir, "foo.tar.gz")
+	require.NoError(t, os.WriteFile(asset, []byte("foo"), 0644))
+
+	key := newTestMinisignKey(t)
+	keyPath := filepath.Join(dir, "minisign.pub")
+	require.NoError(t, os.WriteFile(keyPath, []byte(key.publicKey()), 0644))
+	require.NoError(t, os.WriteFile(asset+".minisig", key.sign("ED", []byte("foo")), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "foo.sig"), key.sign("ED", []byte("foo")), 0644))
+
+	tests := []struct {
+		name    string
+		verify  *VerifySpec
+		recvErr bool
+	}{
+		{"default signature name", &VerifySpec{Type: VerifyMinisign, PublicKey: keyPath}, false},
+		{"inline key", &VerifySpec{Type: VerifyMinisign, PublicKey: minisignLines([]byte(key.publicKey()))[1]}, false},
+		{"signature name", &VerifySpec{Type: VerifyMinisign, PublicKey: keyPath, Signature: "foo.sig"}, false},
+		{"missing signature", &VerifySpec{Type: VerifyMinisign, PublicKey: keyPath, Signature: "{{.Asset}}.asc"}, true},
+		{"missing key", &VerifySpec{Type: VerifyMinisign, PublicKey: filepath.Join(dir, "missing.pub")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewLocalSource(asset, "")
+			require.NoError(t, err)
+			dl, err := s.GetDownloader()
+			require.NoError(t, err)
+			defer dl.Close()
+
+			err = verifySignature(tt.verify, dl, dl)
+			if tt.recvErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestVerifySpec_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		verify  *VerifySpec
+		recvErr bool
+	}{
+		{"minisign", &VerifySpec{Type: VerifyMinisign, PublicKey: "minisign.pub"}, false},
+		{"cosign certificate", &VerifySpec{Type: VerifyCosign, PublicKey: "fulcio.pem", Certificate: "{{.Asset}}.pem", Identity: "release@example.com"}, false},
+		{"unknown type", &VerifySpec{Type: "gpg", PublicKey: "key.asc"}, true},
+		{"no public key", &VerifySpec{Type: VerifyPGP}, true},
+		{"certificate without identity", &VerifySpec{Type: VerifyCosign, PublicKey: "fulcio.pem", Certificate: "{{.Asset}}.pem"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.verify.Validate()
+			if tt.recvErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestReconcilePackage_Signature(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "foo")
+	require.NoError(t, os.WriteFile(src, []byte("#!/bin/sh\n"), 0755))
+
+	key := newTestMinisignKey(t)
+	keyPath := filepath.Join(dir, "minisign.pub")
+	require.NoError(t, os.WriteFile(keyPath, []byte(key.publicKey()), 0644))
+	require.NoError(t, os.WriteFile(src+".minisig", key.sign("ED", []byte("#!/bin/sh\n")), 0644))
+	require.NoError(t, os.WriteFile(src+".bad.minisig", key.sign("ED", []byte("#!/bin/bash\n")), 0644))
+
+	tests := []struct {
+		name      string
+		signature string
+		recvErr   bool
+	}{
+		{"valid", "", false},
+		{"invalid", "{{.Asset}}.bad.minisig", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{CachePath: t.TempDir()}
+			spec := &LocalSpec{
+				CommonSpec: &CommonSpec{
+					From:   "local",
+					Verify: &VerifySpec{Type: VerifyMinisign, PublicKey: keyPath, Signature: tt.signature},
+					config: cfg,
+				},
+				Path: src,
+			}
+
+			err := reconcileTestPackage(&StateData{}, spec)
+			if tt.recvErr {
+				require.Error(t, err)
+				assert.NoDirExists(t, spec.PackagePath())
+				return
+			}
+			require.NoError(t, err)
+			b, err := os.ReadFile(filepath.Join(spec.PackagePath(), currentVersionName, "foo"))
+			require.NoError(t, err)
+			assert.Equal(t, "#!/bin/sh\n", string(b))
+		})
+	}
+}
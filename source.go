@@ -1,9 +1,13 @@
 package gpkg
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"time"
 )
 
 type Source interface {
@@ -17,21 +21,51 @@ type Downloader interface {
 	GetContentLength() int64
 }
 
+// checksumDownloader is implemented by downloaders knowing the SHA-256 digest
+// published for their asset.
+type checksumDownloader interface {
+	ExpectedSHA256() string
+}
+
+// relatedFetcher is implemented by downloaders able to fetch a small file
+// published next to their asset, such as a signature.
+type relatedFetcher interface {
+	fetchRelated(ref string) ([]byte, error)
+}
+
+// warner is implemented by downloaders which have something to tell the user
+// about their asset, such as a published checksum which does not cover it.
+type warner interface {
+	Warning() string
+}
+
 type HTTPDownloader struct {
 	io.ReadCloser
-	name  string
-	total int64
+	name    string
+	total   int64
+	sha256  string
+	url     string
+	header  http.Header
+	warning string
 }
 
 var _ Downloader = &HTTPDownloader{}
+var _ checksumDownloader = &HTTPDownloader{}
+var _ relatedFetcher = &HTTPDownloader{}
+var _ warner = &HTTPDownloader{}
 
 func NewHTTPDownloader(name, url string) (*HTTPDownloader, error) {
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	return newHTTPDownloaderWithHeader(name, url, nil)
+}
+
+// newHTTPDownloaderWithHeader is like NewHTTPDownloader but sends additional
+// headers, such as credentials for private assets.
+func newHTTPDownloaderWithHeader(name, url string, header http.Header) (*HTTPDownloader, error) {
+	req, err := newRequest(http.MethodGet, url, header)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", fmt.Sprintf("gpkg/%s", Version))
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := credentialClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -42,6 +76,8 @@ func NewHTTPDownloader(name, url string) (*HTTPDownloader, error) {
 		ReadCloser: resp.Body,
 		name:       name,
 		total:      resp.ContentLength,
+		url:        url,
+		header:     header,
 	}, nil
 }
 
@@ -52,3 +88,342 @@ func (dl *HTTPDownloader) GetAssetName() string {
 func (dl *HTTPDownloader) GetContentLength() int64 {
 	return dl.total
 }
+
+// ExpectedSHA256 returns the digest published for the asset, or an empty
+// string if none was found.
+func (dl *HTTPDownloader) ExpectedSHA256() string {
+	return dl.sha256
+}
+
+func (dl *HTTPDownloader) Warning() string {
+	return dl.warning
+}
+
+// fetchRelated fetches ref resolved against the URL of the asset. Headers are
+// only sent along if it is on the same host, as they may hold credentials.
+func (dl *HTTPDownloader) fetchRelated(ref string) ([]byte, error) {
+	base, err := url.Parse(dl.url)
+	if err != nil {
+		return nil, err
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	var header http.Header
+	if u.Host == base.Host {
+		header = dl.header
+	}
+	return fetchSmallFile(u.String(), header)
+}
+
+// StreamDownloader serves an archive generated on the fly, such as a snapshot
+// of a git repository. Its length is unknown until it has been read.
+type StreamDownloader struct {
+	io.ReadCloser
+	name string
+}
+
+var _ Downloader = &StreamDownloader{}
+
+// newStreamDownloader returns a downloader reading what write produces.
+// write is called in a new goroutine, and an error returned from it is
+// reported by Read.
+func newStreamDownloader(name string, write func(w io.Writer) error) *StreamDownloader {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(write(pw))
+	}()
+	return &StreamDownloader{
+		ReadCloser: pr,
+		name:       name,
+	}
+}
+
+func (dl *StreamDownloader) GetAssetName() string {
+	return dl.name
+}
+
+func (dl *StreamDownloader) GetContentLength() int64 {
+	return -1
+}
+
+func newRequest(method, url string, header http.Header) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("gpkg/%s", Version))
+	return req, nil
+}
+
+// credentialClient is a client for requests which may carry credentials in
+// their headers. The default client forwards any header but Authorization and
+// Cookie to a redirect leaving the host, such as PRIVATE-TOKEN of GitLab, while
+// this one drops every header set by the caller.
+var credentialClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if req.URL.Host != via[0].URL.Host {
+			for k := range via[0].Header {
+				if k != "User-Agent" && k != "Accept" {
+					req.Header.Del(k)
+				}
+			}
+		}
+		return nil
+	},
+}
+
+// headerForHost returns header if rawURL is on the host of base, and nil
+// otherwise, so that the credentials for an API are not sent to wherever a
+// release links to.
+func headerForHost(header http.Header, rawURL, base string) http.Header {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	b, err := url.Parse(base)
+	if err != nil || u.Host != b.Host {
+		return nil
+	}
+	return header
+}
+
+// fetchSmallFile downloads a file expected to be small, such as a checksum
+// file or a signature, into memory.
+func fetchSmallFile(url string, header http.Header) ([]byte, error) {
+	req, err := newRequest(http.MethodGet, url, header)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := credentialClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status code was returned. expected=200, got=%d, url=%s", resp.StatusCode, url)
+	}
+	// Anything larger is not worth reading.
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// getJSON sends a GET request to url and decodes the JSON response into v.
+func getJSON(client *http.Client, url string, header http.Header, v interface{}) error {
+	req, err := newRequest(http.MethodGet, url, header)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("unexpected status code was returned. expected=200, got=%d, url=%s", resp.StatusCode, url)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response. url=%s, err=%v", url, err)
+	}
+	return nil
+}
+
+// remoteAsset is the asset a source downloads, resolved to its URL without
+// being downloaded.
+type remoteAsset struct {
+	name    string
+	url     string
+	header  http.Header
+	sha256  string
+	warning string
+}
+
+// assetResolver is implemented by sources downloading an asset from a URL,
+// which can tell what they download without opening it.
+type assetResolver interface {
+	resolveAsset() (*remoteAsset, error)
+}
+
+var _ assetResolver = &GitHubRelease{}
+var _ assetResolver = &GitLabRelease{}
+var _ assetResolver = &GiteaRelease{}
+var _ assetResolver = &URLSource{}
+
+// open starts downloading the asset.
+func (ra *remoteAsset) open() (*HTTPDownloader, error) {
+	dl, err := newHTTPDownloaderWithHeader(ra.name, ra.url, ra.header)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create a downloader. err=%s", err)
+	}
+	dl.sha256 = ra.sha256
+	dl.warning = ra.warning
+	return dl, nil
+}
+
+// resolveReleaseAsset resolves asset of a release along with the checksum
+// published for it among assets. headerFor returns the headers to send to a
+// URL, and may be nil.
+func resolveReleaseAsset(asset releaseAsset, assets []releaseAsset, headerFor func(rawURL string) http.Header) (*remoteAsset, error) {
+	digest, warning, err := releaseChecksum(asset, assets, headerFor)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the checksum. err=%s", err)
+	}
+
+	ra := &remoteAsset{
+		name:    asset.name,
+		url:     asset.url,
+		sha256:  digest,
+		warning: warning,
+	}
+	if headerFor != nil {
+		ra.header = headerFor(asset.url)
+	}
+	return ra, nil
+}
+
+// assetLister is implemented by sources downloading an asset among the ones
+// attached to a release.
+type assetLister interface {
+	listAssets() (tag string, assets []releaseAsset, err error)
+}
+
+var _ assetLister = &GitHubRelease{}
+var _ assetLister = &GitLabRelease{}
+var _ assetLister = &GiteaRelease{}
+
+// releaseSource is what the sources of releases share. Each hosting service
+// only fetches the release ref resolves to, and the asset for the platform is
+// picked among its assets here.
+type releaseSource struct {
+	ref        string
+	rules      *assetRules
+	prerelease bool
+	platform   platform
+	// fetch returns the tag of the release ref resolves to and its assets.
+	fetch func() (tag string, assets []releaseAsset, err error)
+	// headerFor returns the headers to send to a URL, and may be nil.
+	headerFor func(rawURL string) http.Header
+
+	fetched bool
+	tag     string
+	assets  []releaseAsset
+}
+
+func newReleaseSource(ref string, fetch func() (string, []releaseAsset, error), headerFor func(string) http.Header) releaseSource {
+	return releaseSource{
+		ref:       ref,
+		platform:  hostPlatform(),
+		fetch:     fetch,
+		headerFor: headerFor,
+	}
+}
+
+// listAssets returns the tag of the release ref resolves to and its assets.
+// The release is fetched once, so that the ref ShouldUpdate reports is the one
+// of the asset downloaded, even if a release is published in between.
+func (rs *releaseSource) listAssets() (string, []releaseAsset, error) {
+	if !rs.fetched {
+		tag, assets, err := rs.fetch()
+		if err != nil {
+			return "", nil, err
+		}
+		rs.tag, rs.assets, rs.fetched = tag, assets, true
+	}
+	return rs.tag, rs.assets, nil
+}
+
+func (rs *releaseSource) GetDownloader() (Downloader, error) {
+	ra, err := rs.resolveAsset()
+	if err != nil {
+		return nil, err
+	}
+	return ra.open()
+}
+
+// resolveAsset returns the asset to download for the platform along with the
+// checksum published for it.
+func (rs *releaseSource) resolveAsset() (*remoteAsset, error) {
+	_, assets, err := rs.listAssets()
+	if err != nil {
+		return nil, err
+	}
+
+	asset, ok := rs.rules.find(rs.platform, assets)
+	if !ok {
+		return nil, fmt.Errorf("No compatible asset found. ref=%s", rs.ref)
+	}
+
+	return resolveReleaseAsset(asset, assets, rs.headerFor)
+}
+
+func (rs *releaseSource) ShouldUpdate(currentRef string) (bool, string, error) {
+	if isFloatingRef(rs.ref) {
+		tag, _, err := rs.listAssets()
+		if err != nil {
+			return false, "", err
+		}
+		return tag != currentRef, tag, nil
+	}
+	return rs.ref != currentRef, rs.ref, nil
+}
+
+// releaseAsset is a file attached to a release, independent of the hosting
+// service.
+type releaseAsset struct {
+	name string
+	url  string
+}
+
+// releaseSummary is what a release is picked by among the ones of a
+// repository.
+type releaseSummary struct {
+	tag        string
+	published  time.Time
+	draft      bool
+	prerelease bool
+}
+
+// listsReleases reports whether ref is resolved by listing releases rather
+// than asking for the latest one or a tag. Only the newest release, which may
+// be a prerelease, or a version constraint needs the list.
+func listsReleases(ref string, prerelease bool) bool {
+	return ref == "newest" || isVersionConstraint(ref) || (prerelease && (ref == "" || ref == "latest"))
+}
+
+// pickRelease returns the index of the release ref resolves to among rels.
+// Drafts are never picked, and prereleases only if prerelease is true.
+func pickRelease(ref string, prerelease bool, rels []releaseSummary) (int, error) {
+	if isVersionConstraint(ref) {
+		// Releases which cannot be picked are left blank.
+		tags := make([]string, len(rels))
+		for i, rel := range rels {
+			if !rel.draft && (prerelease || !rel.prerelease) {
+				tags[i] = rel.tag
+			}
+		}
+		return pickVersion(ref, tags, prerelease)
+	}
+
+	found := -1
+	for i, rel := range rels {
+		if rel.draft || (rel.prerelease && !prerelease) {
+			continue
+		}
+		if found < 0 || rel.published.After(rels[found].published) {
+			found = i
+		}
+	}
+	if found < 0 {
+		return -1, fmt.Errorf("No release found")
+	}
+	return found, nil
+}
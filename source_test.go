@@ -2,7 +2,10 @@ package gpkg
 
 import (
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -58,3 +61,85 @@ func TestHTTPDownloader_GetContentLength(t *testing.T) {
 	dl, _, msg := defaultTestHTTPDownloader(t)
 	assert.Equal(t, dl.GetContentLength(), int64(len(msg)))
 }
+
+func TestPickRelease(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2023, 6, d, 0, 0, 0, 0, time.UTC)
+	}
+	rels := []releaseSummary{
+		{tag: "v2.0.0-rc.1", published: day(5), prerelease: true},
+		{tag: "v2.0.0-beta.1", published: day(6), draft: true},
+		{tag: "v1.1.0", published: day(4)},
+		// A backport published after v1.1.0
+		{tag: "v1.0.1", published: day(5)},
+		{tag: "v1.0.0", published: day(1)},
+	}
+	tests := []struct {
+		ref        string
+		prerelease bool
+		expected   string
+	}{
+		{"newest", false, "v1.0.1"},
+		{"newest", true, "v2.0.0-rc.1"},
+		{"latest", true, "v2.0.0-rc.1"},
+		{"^1", false, "v1.1.0"},
+		{"~1.0", false, "v1.0.1"},
+		{">=1", false, "v1.1.0"},
+		{">=1", true, "v2.0.0-rc.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			i, err := pickRelease(tt.ref, tt.prerelease, rels)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, rels[i].tag)
+		})
+	}
+
+	t.Run("no release", func(t *testing.T) {
+		_, err := pickRelease("newest", false, rels[:2])
+		require.Error(t, err)
+	})
+}
+
+func TestListsReleases(t *testing.T) {
+	assert.False(t, listsReleases("latest", false))
+	assert.False(t, listsReleases("", false))
+	assert.False(t, listsReleases("v1.0.0", true))
+	assert.True(t, listsReleases("latest", true))
+	assert.True(t, listsReleases("", true))
+	assert.True(t, listsReleases("newest", false))
+	assert.True(t, listsReleases("~1.0", false))
+}
+
+func TestCredentialClient_Redirect(t *testing.T) {
+	var got []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("PRIVATE-TOKEN"))
+	}))
+	defer other.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		case "/other":
+			http.Redirect(w, r, other.URL+"/final", http.StatusFound)
+		default:
+			got = append(got, r.Header.Get("PRIVATE-TOKEN"))
+		}
+	}))
+	defer srv.Close()
+
+	header := http.Header{"Private-Token": {"secret"}}
+	_, err := fetchSmallFile(srv.URL+"/same", header)
+	require.NoError(t, err)
+	_, err = fetchSmallFile(srv.URL+"/other", header)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"secret", ""}, got)
+}
+
+func TestHeaderForHost(t *testing.T) {
+	header := http.Header{"Private-Token": {"secret"}}
+	assert.Equal(t, header, headerForHost(header, "https://gitlab.example.com/foo", "https://gitlab.example.com"))
+	assert.Nil(t, headerForHost(header, "https://example.com/foo", "https://gitlab.example.com"))
+	assert.Nil(t, headerForHost(header, "https://gitlab.example.com:8443/foo", "https://gitlab.example.com"))
+}
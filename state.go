@@ -7,6 +7,9 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 )
@@ -15,10 +18,22 @@ type State struct {
 	Spec PackageSpec `json:"spec"`
 	Path string      `json:"path"`
 	Ref  string      `json:"ref"`
+	// SHA256 is the digest of the asset installed, which has been verified
+	// when a checksum was published or given in the spec.
+	SHA256 string `json:"sha256,omitempty"`
+	// InstalledAt is when Ref was installed. It is missing from states
+	// written by older versions.
+	InstalledAt *time.Time `json:"installed_at,omitempty"`
+	// Previous are the versions installed before Ref which are kept for
+	// rollback, most recent first.
+	Previous []InstalledVersion `json:"previous,omitempty"`
 }
 
+// StateData is safe for concurrent use by multiple goroutines.
 type StateData struct {
 	States []State `json:"states"`
+
+	mu sync.Mutex
 }
 
 func DecodeStateData(r io.Reader) (*StateData, error) {
@@ -31,6 +46,7 @@ func DecodeStateData(r io.Reader) (*StateData, error) {
 	o := &mapstructure.DecoderConfig{}
 	o.Result = sd
 	DecoderConfigOption(&Config{})(o)
+	o.DecodeHook = mapstructure.ComposeDecodeHookFunc(o.DecodeHook, mapstructure.StringToTimeHookFunc(time.RFC3339))
 
 	dec, _ := mapstructure.NewDecoder(o)
 	if err := dec.Decode(raw); err != nil {
@@ -65,6 +81,9 @@ func LoadStateDataFromFile(path string) (*StateData, error) {
 }
 
 func (sd *StateData) Save(w io.Writer) error {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
 	bs, err := json.MarshalIndent(sd, "", "  ")
 	if err != nil {
 		return fmt.Errorf("Failed to encoding states to JSON. err=%v", err)
@@ -86,6 +105,12 @@ func (sd *StateData) SaveToFile(path string) error {
 }
 
 func (sd *StateData) FindState(spec PackageSpec) (int, *State, error) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	return sd.findState(spec)
+}
+
+func (sd *StateData) findState(spec PackageSpec) (int, *State, error) {
 	var found *State
 	idx := -1
 	for i, st := range sd.States {
@@ -101,13 +126,19 @@ func (sd *StateData) FindState(spec PackageSpec) (int, *State, error) {
 	return idx, found, nil
 }
 
-func (sd *StateData) Upsert(spec PackageSpec, ref string) {
-	idx, _, err := sd.FindState(spec)
+func (sd *StateData) Upsert(spec PackageSpec, ref, sha256 string) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	idx, st, err := sd.findState(spec)
 
+	now := time.Now()
 	s0 := State{
-		Spec: spec,
-		Path: spec.PackagePath(),
-		Ref:  ref,
+		Spec:        spec,
+		Path:        filepath.Join(spec.PackagePath(), currentVersionName),
+		Ref:         ref,
+		SHA256:      sha256,
+		InstalledAt: &now,
 	}
 
 	if err != nil {
@@ -115,6 +146,40 @@ func (sd *StateData) Upsert(spec PackageSpec, ref string) {
 		sd.States = append(sd.States, s0)
 	} else {
 		// Found
+		if st.Ref != ref {
+			s0.Previous = append(s0.Previous, InstalledVersion{Ref: st.Ref, SHA256: st.SHA256, InstalledAt: st.InstalledAt})
+		}
+		for _, v := range st.Previous {
+			if v.Ref != ref && v.Ref != st.Ref {
+				s0.Previous = append(s0.Previous, v)
+			}
+		}
 		sd.States[idx] = s0
 	}
 }
+
+// update calls f with the state of spec, which f may modify.
+func (sd *StateData) update(spec PackageSpec, f func(st *State)) error {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	idx, _, err := sd.findState(spec)
+	if err != nil {
+		return err
+	}
+	f(&sd.States[idx])
+	return nil
+}
+
+// Remove forgets the state of spec, and reports whether there was one.
+func (sd *StateData) Remove(spec PackageSpec) bool {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	idx, _, err := sd.findState(spec)
+	if err != nil {
+		return false
+	}
+	sd.States = append(sd.States[:idx], sd.States[idx+1:]...)
+	return true
+}
@@ -2,9 +2,12 @@ package gpkg
 
 import (
 	"bytes"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -49,6 +52,41 @@ func TestDecodeStateData(t *testing.T) {
 			},
 			ok,
 		},
+		{
+			"accept a gitlab release spec",
+			`
+			{
+				"states": [
+					{
+						"spec": {
+							"from": "glr",
+							"repo": "foo/bar",
+							"base_url": "https://gitlab.example.com",
+							"token_env": "EXAMPLE_TOKEN"
+						},
+						"path": "/tmp/bin/bar"
+					}
+				]
+			}
+			`,
+			&StateData{
+				States: []State{
+					{
+						Spec: &GitLabReleaseSpec{
+							CommonSpec: &CommonSpec{
+								From:   "glr",
+								config: &Config{},
+							},
+							Repo:     "foo/bar",
+							BaseURL:  "https://gitlab.example.com",
+							TokenEnv: "EXAMPLE_TOKEN",
+						},
+						Path: "/tmp/bin/bar",
+					},
+				},
+			},
+			ok,
+		},
 		{
 			"reject corrupted json",
 			"{",
@@ -216,20 +254,20 @@ func TestStateData_Upsert(t *testing.T) {
 	tests := []struct {
 		name        string
 		input       PackageSpec
-		initialData StateData
-		expected    StateData
+		initialData *StateData
+		expected    *StateData
 	}{
 		{
 			"add a first state",
 			foo,
-			StateData{
+			&StateData{
 				States: []State{},
 			},
-			StateData{
+			&StateData{
 				States: []State{
 					{
 						Spec: foo,
-						Path: foo.PackagePath(),
+						Path: filepath.Join(foo.PackagePath(), currentVersionName),
 						Ref:  foo.Ref,
 					},
 				},
@@ -238,7 +276,7 @@ func TestStateData_Upsert(t *testing.T) {
 		{
 			"add a state",
 			foo,
-			StateData{
+			&StateData{
 				States: []State{
 					{
 						Spec: bar,
@@ -247,7 +285,7 @@ func TestStateData_Upsert(t *testing.T) {
 					},
 				},
 			},
-			StateData{
+			&StateData{
 				States: []State{
 					{
 						Spec: bar,
@@ -256,7 +294,7 @@ func TestStateData_Upsert(t *testing.T) {
 					},
 					{
 						Spec: foo,
-						Path: foo.PackagePath(),
+						Path: filepath.Join(foo.PackagePath(), currentVersionName),
 						Ref:  foo.Ref,
 					},
 				},
@@ -265,7 +303,7 @@ func TestStateData_Upsert(t *testing.T) {
 		{
 			"update an existing state",
 			fooV2,
-			StateData{
+			&StateData{
 				States: []State{
 					{
 						Spec: foo,
@@ -274,12 +312,13 @@ func TestStateData_Upsert(t *testing.T) {
 					},
 				},
 			},
-			StateData{
+			&StateData{
 				States: []State{
 					{
-						Spec: fooV2,
-						Path: fooV2.PackagePath(),
-						Ref:  fooV2.Ref,
+						Spec:     fooV2,
+						Path:     filepath.Join(fooV2.PackagePath(), currentVersionName),
+						Ref:      fooV2.Ref,
+						Previous: []InstalledVersion{{Ref: foo.Ref}},
 					},
 				},
 			},
@@ -288,8 +327,49 @@ func TestStateData_Upsert(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			d := tt.initialData
-			d.Upsert(tt.input, tt.input.Common().Ref)
+			d.Upsert(tt.input, tt.input.Common().Ref, "")
+			_, st, err := d.FindState(tt.input)
+			require.NoError(t, err)
+			require.NotNil(t, st.InstalledAt)
+			assert.WithinDuration(t, time.Now(), *st.InstalledAt, time.Minute)
+			// The time is checked above.
+			for i := range d.States {
+				d.States[i].InstalledAt = nil
+			}
 			assert.EqualValues(t, tt.expected, d)
 		})
 	}
 }
+
+func TestStateData_Upsert_Concurrent(t *testing.T) {
+	sd := &StateData{}
+	n := 100
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sd.Upsert(NewNopSpec(fmt.Sprintf("pkg%03d", i)), "v1", "")
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, sd.States, n)
+	for i := 0; i < n; i++ {
+		_, _, err := sd.FindState(NewNopSpec(fmt.Sprintf("pkg%03d", i)))
+		require.NoError(t, err)
+	}
+}
+
+func TestStateData_Remove(t *testing.T) {
+	foo, bar := NewNopSpec("foo"), NewNopSpec("bar")
+	states := &StateData{}
+	states.Upsert(foo, "v1", "")
+	states.Upsert(bar, "v1", "")
+
+	assert.True(t, states.Remove(foo))
+	assert.False(t, states.Remove(foo))
+	require.Len(t, states.States, 1)
+	assert.Equal(t, "bar", states.States[0].Spec.Unique())
+}
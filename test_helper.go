@@ -2,8 +2,10 @@ package gpkg
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"bytes"
 	"compress/gzip"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -34,12 +36,23 @@ func assertDirectoryContents(t *testing.T, dir string, expectedFiles []string) {
 	assert.Equal(t, expectedFiles, files)
 }
 
-// Build an in memory archive with the specified files, writing the path as each
-// file's contents when applicable.
+// Build an in memory gzip-compressed archive with the specified files. See makeTar.
 func makeTarGz(t *testing.T, files []*tar.Header) *bytes.Buffer {
 	var archive bytes.Buffer
 	gzw := gzip.NewWriter(&archive)
-	tw := tar.NewWriter(gzw)
+	_, err := io.Copy(gzw, makeTar(t, files))
+	require.NoError(t, err)
+	err = gzw.Close()
+	require.NoError(t, err)
+
+	return &archive
+}
+
+// Build an in memory archive with the specified files, writing the path as each
+// file's contents when applicable.
+func makeTar(t *testing.T, files []*tar.Header) *bytes.Buffer {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
 
 	for _, f := range files {
 		if f.Typeflag == tar.TypeReg {
@@ -60,7 +73,26 @@ func makeTarGz(t *testing.T, files []*tar.Header) *bytes.Buffer {
 
 	err := tw.Close()
 	require.NoError(t, err)
-	err = gzw.Close()
+
+	return &archive
+}
+
+// Build an in memory zip archive with the specified files, writing the path as
+// each file's contents when applicable.
+func makeZip(t *testing.T, files []*zip.FileHeader) *bytes.Buffer {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+
+	for _, f := range files {
+		w, err := zw.CreateHeader(f)
+		require.NoError(t, err)
+		if f.Mode().IsRegular() {
+			_, err = w.Write([]byte(f.Name))
+			require.NoError(t, err)
+		}
+	}
+
+	err := zw.Close()
 	require.NoError(t, err)
 
 	return &archive
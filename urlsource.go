@@ -0,0 +1,196 @@
+package gpkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// URLSource is a source downloading a package from a URL built from a
+// template, for tools published on their own download servers.
+type URLSource struct {
+	tmpl        *template.Template
+	ref         string
+	versionURL  string
+	versionPath string
+	os          string
+	arch        string
+	client      *http.Client
+
+	version string
+}
+
+// URLTemplateData is passed to the URL template of a url spec.
+type URLTemplateData struct {
+	Version string
+	OS      string
+	Arch    string
+}
+
+var urlTemplateFuncs = template.FuncMap{
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+}
+
+func NewURLSource(spec *URLSpec, client *http.Client) (*URLSource, error) {
+	return newURLSource(spec, spec.Ref, runtime.GOOS, runtime.GOARCH, client)
+}
+
+// newURLSource is like NewURLSource but resolves ref, which replaces the one
+// in the spec, for goos and goarch.
+func newURLSource(spec *URLSpec, ref, goos, goarch string, client *http.Client) (*URLSource, error) {
+	tmpl, err := template.New("url").Funcs(urlTemplateFuncs).Option("missingkey=error").Parse(spec.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the url template. err=%v", err)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	src := &URLSource{
+		tmpl:        tmpl,
+		ref:         ref,
+		versionURL:  spec.VersionURL,
+		versionPath: spec.VersionPath,
+		os:          goos,
+		arch:        goarch,
+		client:      client,
+	}
+	if v, ok := spec.OSMap[src.os]; ok {
+		src.os = v
+	}
+	if v, ok := spec.ArchMap[src.arch]; ok {
+		src.arch = v
+	}
+	return src, nil
+}
+
+func (s *URLSource) resolveVersion() (string, error) {
+	if s.version != "" {
+		return s.version, nil
+	}
+	if s.ref != "latest" && s.ref != "" {
+		s.version = s.ref
+		return s.version, nil
+	}
+	if s.versionURL == "" {
+		return "", fmt.Errorf("version_url is required to resolve the latest version")
+	}
+
+	req, err := newRequest(http.MethodGet, s.versionURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("unexpected status code was returned. expected=200, got=%d, url=%s", resp.StatusCode, s.versionURL)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var v string
+	if s.versionPath == "" {
+		v = strings.TrimSpace(string(body))
+	} else if v, err = lookupJSONPath(body, s.versionPath); err != nil {
+		return "", err
+	}
+	if v == "" {
+		return "", fmt.Errorf("empty version was returned. url=%s", s.versionURL)
+	}
+	s.version = v
+	return s.version, nil
+}
+
+// lookupJSONPath returns the string at the dot-separated path in a JSON
+// document. Array elements are addressed by their index, e.g. `0.tag_name`.
+func lookupJSONPath(doc []byte, p string) (string, error) {
+	var v interface{}
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return "", fmt.Errorf("failed to decode the version response. err=%v", err)
+	}
+	for _, key := range strings.Split(p, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", fmt.Errorf("invalid index in version_path. path=%s, key=%s", p, key)
+			}
+			v = node[i]
+		default:
+			return "", fmt.Errorf("version_path not found. path=%s", p)
+		}
+	}
+	switch node := v.(type) {
+	case string:
+		return node, nil
+	case float64:
+		return strconv.FormatFloat(node, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("version_path does not point to a string. path=%s", p)
+	}
+}
+
+func (s *URLSource) render() (string, error) {
+	v, err := s.resolveVersion()
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	data := URLTemplateData{
+		Version: v,
+		OS:      s.os,
+		Arch:    s.arch,
+	}
+	if err := s.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render the url template. err=%v", err)
+	}
+	return buf.String(), nil
+}
+
+func (s *URLSource) GetDownloader() (Downloader, error) {
+	ra, err := s.resolveAsset()
+	if err != nil {
+		return nil, err
+	}
+	return ra.open()
+}
+
+// resolveAsset returns the asset the rendered URL points to.
+func (s *URLSource) resolveAsset() (*remoteAsset, error) {
+	u, err := s.render()
+	if err != nil {
+		return nil, err
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteAsset{name: path.Base(pu.Path), url: u}, nil
+}
+
+func (s *URLSource) ShouldUpdate(currentRef string) (bool, string, error) {
+	v, err := s.resolveVersion()
+	if err != nil {
+		return false, "", err
+	}
+	return v != currentRef, v, nil
+}
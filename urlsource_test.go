@@ -0,0 +1,150 @@
+package gpkg
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newURLTestServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/VERSION", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("1.2.3\n"))
+	})
+	mux.HandleFunc("/releases.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"version": "v2.0.0"}, {"version": "v1.0.0"}]`))
+	})
+	mux.HandleFunc("/tool/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestURLSpec(url, ref string) *URLSpec {
+	return &URLSpec{
+		CommonSpec: &CommonSpec{
+			From: "url",
+			Ref:  ref,
+			ID:   "tool",
+		},
+		URL: url,
+	}
+}
+
+func TestURLSource_ShouldUpdate(t *testing.T) {
+	srv := newURLTestServer(t)
+	tests := []struct {
+		name        string
+		ref         string
+		versionURL  string
+		versionPath string
+		currentRef  string
+		expected    bool
+		nextRef     string
+		recvErr     bool
+	}{
+		{"pinned version", "1.0.0", "", "", "", true, "1.0.0", false},
+		{"pinned version is installed", "1.0.0", "", "", "1.0.0", false, "1.0.0", false},
+		{"plain text version", "latest", srv.URL + "/VERSION", "", "1.0.0", true, "1.2.3", false},
+		{"json path", "", srv.URL + "/releases.json", "0.version", "v2.0.0", false, "v2.0.0", false},
+		{"json path not found", "", srv.URL + "/releases.json", "0.name", "", false, "", true},
+		{"version url not found", "", srv.URL + "/404", "", "", false, "", true},
+		{"no version url", "latest", "", "", "", false, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := newTestURLSpec(srv.URL+"/tool/{{.Version}}", tt.ref)
+			spec.VersionURL = tt.versionURL
+			spec.VersionPath = tt.versionPath
+			src, err := NewURLSource(spec, nil)
+			require.NoError(t, err)
+
+			yes, next, err := src.ShouldUpdate(tt.currentRef)
+			if tt.recvErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, yes)
+			assert.Equal(t, tt.nextRef, next)
+		})
+	}
+}
+
+func TestURLSource_GetDownloader(t *testing.T) {
+	srv := newURLTestServer(t)
+
+	t.Run("render a template", func(t *testing.T) {
+		spec := newTestURLSpec(srv.URL+"/tool/{{.Version}}/tool_{{.OS}}_{{.Arch}}.tar.gz", "1.2.3")
+		src, err := NewURLSource(spec, nil)
+		require.NoError(t, err)
+
+		dl, err := src.GetDownloader()
+		require.NoError(t, err)
+		defer dl.Close()
+		assert.Equal(t, fmt.Sprintf("tool_%s_%s.tar.gz", runtime.GOOS, runtime.GOARCH), dl.GetAssetName())
+	})
+
+	t.Run("os and arch mappings", func(t *testing.T) {
+		spec := newTestURLSpec(srv.URL+`/tool/{{.Version | trimPrefix "v"}}/tool_{{.OS}}_{{.Arch}}`, "")
+		spec.VersionURL = srv.URL + "/releases.json"
+		spec.VersionPath = "0.version"
+		spec.OSMap = map[string]string{runtime.GOOS: "MyOS"}
+		spec.ArchMap = map[string]string{runtime.GOARCH: "myarch"}
+		src, err := NewURLSource(spec, nil)
+		require.NoError(t, err)
+
+		dl, err := src.GetDownloader()
+		require.NoError(t, err)
+		defer dl.Close()
+		assert.Equal(t, "tool_MyOS_myarch", dl.GetAssetName())
+	})
+
+	t.Run("invalid template", func(t *testing.T) {
+		_, err := NewURLSource(newTestURLSpec(srv.URL+"/tool/{{.Version", "1.2.3"), nil)
+		require.Error(t, err)
+	})
+
+	t.Run("unknown field in template", func(t *testing.T) {
+		src, err := NewURLSource(newTestURLSpec(srv.URL+"/tool/{{.Unknown}}", "1.2.3"), nil)
+		require.NoError(t, err)
+		_, err = src.GetDownloader()
+		require.Error(t, err)
+	})
+}
+
+func TestLookupJSONPath(t *testing.T) {
+	tests := []struct {
+		doc      string
+		path     string
+		expected string
+		recvErr  bool
+	}{
+		{`{"tag_name": "v1.0.0"}`, "tag_name", "v1.0.0", false},
+		{`{"latest": {"version": "1.2"}}`, "latest.version", "1.2", false},
+		{`[{"name": "v2"}, {"name": "v1"}]`, "1.name", "v1", false},
+		{`{"build": 42}`, "build", "42", false},
+		{`[{"name": "v2"}]`, "2.name", "", true},
+		{`{"latest": {"version": "1.2"}}`, "latest", "", true},
+		{`{"tag_name": "v1.0.0"}`, "tag_name.foo", "", true},
+		{`not json`, "tag_name", "", true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test%02d", i), func(t *testing.T) {
+			got, err := lookupJSONPath([]byte(tt.doc), tt.path)
+			if tt.recvErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
@@ -2,39 +2,228 @@ package gpkg
 
 import (
 	"archive/tar"
-	"bytes"
+	"archive/zip"
+	"bufio"
+	"compress/bzip2"
 	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
 	"github.com/h2non/filetype"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 )
 
-func extract(r io.Reader, path, name string) error {
-	b, err := io.ReadAll(r)
+// sniffLen is the size of the prefix peeked to detect the file type, which is
+// as large as what filetype.MatchReader reads.
+const sniffLen = 8192
+
+// extractOptions controls how the entries of an archive are laid out.
+type extractOptions struct {
+	// stripComponents is the number of leading components removed from the
+	// name of each entry. Entries with no components left are skipped.
+	stripComponents int
+	// stripTopDir moves the contents of the top-level directory up when it is
+	// the only entry of the archive.
+	stripTopDir bool
+}
+
+// extract streams r into the directory dst. The file type is detected from a
+// peeked prefix, so that archives are never held in memory as a whole. r is
+// read to the end even if the archive ends earlier, e.g. with tar padding.
+func extract(r io.Reader, dst, name string, opts extractOptions) error {
+	br := bufio.NewReaderSize(r, sniffLen)
+	header, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	ft, err := filetype.Match(header)
+	if err != nil {
+		return err
+	}
+
+	if c := detectCompression(ft.MIME.Value, name); c != nil {
+		err = extractCompressed(br, dst, name, c, opts.stripComponents)
+	} else {
+		switch ft.MIME.Value {
+		case "application/x-tar":
+			err = extractTar(br, dst, opts.stripComponents)
+		case "application/zip":
+			err = extractZipStream(br, dst, opts.stripComponents)
+		default:
+			err = copyFile(br, filepath.Join(dst, name), 0755)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(io.Discard, br); err != nil {
+		return err
+	}
+
+	if opts.stripTopDir {
+		return stripTopDir(dst)
+	}
+	return nil
+}
+
+// stripPathComponents removes n leading components from the name of an archive
+// entry. ok is false when no component is left.
+func stripPathComponents(name string, n int) (stripped string, ok bool) {
+	if n <= 0 {
+		return name, true
+	}
+	parts := strings.Split(strings.TrimPrefix(path.Clean(filepath.ToSlash(name)), "/"), "/")
+	if len(parts) <= n {
+		return "", false
+	}
+	return path.Join(parts[n:]...), true
+}
+
+// stripTopDir moves the contents of the only directory in dst up into dst, so
+// that the layout does not depend on a top-level directory named after the
+// version. Nothing is done if dst has any other entry.
+func stripTopDir(dst string) error {
+	entries, err := os.ReadDir(dst)
 	if err != nil {
 		return err
 	}
-	br := bytes.NewReader(b)
+	if len(entries) != 1 || !entries[0].IsDir() {
+		return nil
+	}
 
-	ft, err := filetype.MatchReader(br)
+	// Rename the directory first since it may contain an entry of the same name.
+	top := filepath.Join(dst, ".gpkg-strip")
+	if entries[0].Name() == filepath.Base(top) {
+		top += "-"
+	}
+	if err := os.Rename(filepath.Join(dst, entries[0].Name()), top); err != nil {
+		return err
+	}
+	children, err := os.ReadDir(top)
 	if err != nil {
 		return err
 	}
+	for _, c := range children {
+		if err := os.Rename(filepath.Join(top, c.Name()), filepath.Join(dst, c.Name())); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(top); err != nil {
+		return err
+	}
+
+	// Relative symlinks may lead outside now that they are one level higher.
+	root, err := filepath.EvalSymlinks(dst)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.Type()&fs.ModeSymlink == 0 {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		if _, err := resolveInRoot(root, rel); err != nil {
+			return fmt.Errorf("failed to sanitize link target: %s", rel)
+		}
+		return nil
+	})
+}
+
+// compression is a format that tarballs are compressed with.
+type compression struct {
+	name       string
+	mime       string
+	extensions []string
+	newReader  func(io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	gzipCompression = &compression{
+		name:       "gzip",
+		mime:       "application/gzip",
+		extensions: []string{".gz", ".tgz"},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	}
+	xzCompression = &compression{
+		name:       "xz",
+		mime:       "application/x-xz",
+		extensions: []string{".xz", ".txz"},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			xr, err := xz.NewReader(r)
+			return io.NopCloser(xr), err
+		},
+	}
+	bzip2Compression = &compression{
+		name:       "bzip2",
+		mime:       "application/x-bzip2",
+		extensions: []string{".bz2", ".tbz", ".tbz2"},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(bzip2.NewReader(r)), nil
+		},
+	}
+	zstdCompression = &compression{
+		name:       "zstd",
+		mime:       "application/zstd",
+		extensions: []string{".zst", ".tzst"},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			zr, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return zr.IOReadCloser(), nil
+		},
+	}
 
-	br.Seek(0, io.SeekStart)
+	compressions = []*compression{
+		gzipCompression,
+		xzCompression,
+		bzip2Compression,
+		zstdCompression,
+	}
+)
 
-	switch ft.MIME.Value {
-	case "application/gzip":
-		return extractTarGz(br, path)
-	default:
-		return copyFile(br, filepath.Join(path, name), 0755)
+// trimExtension returns name without the suffix of the compression format.
+func (c *compression) trimExtension(name string) string {
+	for _, ext := range c.extensions {
+		if strings.HasSuffix(strings.ToLower(name), ext) && len(name) > len(ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
+
+// detectCompression returns the compression identified by the MIME type
+// sniffed from the magic bytes. The asset name is only consulted when the
+// magic bytes are not recognized.
+func detectCompression(mime, name string) *compression {
+	for _, c := range compressions {
+		if c.mime == mime {
+			return c
+		}
 	}
+	if mime != "" {
+		return nil
+	}
+	name = strings.ToLower(name)
+	for _, c := range compressions {
+		for _, ext := range c.extensions {
+			if strings.HasSuffix(name, ext) {
+				return c
+			}
+		}
+	}
+	return nil
 }
 
 func copyFile(src io.Reader, dstPath string, perm fs.FileMode) error {
@@ -50,18 +239,42 @@ func copyFile(src io.Reader, dstPath string, perm fs.FileMode) error {
 	return nil
 }
 
-func extractTarGz(r io.Reader, dst string) error {
+// extractCompressed extracts a compressed tarball, or decompresses a single
+// compressed file into an executable named after the asset without the
+// compression suffix.
+func extractCompressed(r io.Reader, dst, name string, c *compression, strip int) error {
 	if dst == "" {
 		return errors.New("no destination path provided.")
 	}
 
-	gzr, err := gzip.NewReader(r)
+	cr, err := c.newReader(r)
 	if err != nil {
-		return fmt.Errorf("failed to create gzip reader: %s", err)
+		return fmt.Errorf("failed to create %s reader: %s", c.name, err)
 	}
-	defer gzr.Close()
+	defer cr.Close()
 
-	tr := tar.NewReader(gzr)
+	br := bufio.NewReader(cr)
+	header, err := br.Peek(262)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to decompress %s: %s", name, err)
+	}
+	if ft, _ := filetype.Match(header); ft.MIME.Value == "application/x-tar" {
+		return extractTar(br, dst, strip)
+	}
+	return copyFile(br, filepath.Join(dst, c.trimExtension(name)), 0755)
+}
+
+func extractTar(r io.Reader, dst string, strip int) error {
+	if dst == "" {
+		return errors.New("no destination path provided.")
+	}
+	root, err := filepath.EvalSymlinks(dst)
+	if err != nil {
+		return err
+	}
+
+	var symlinks []string
+	tr := tar.NewReader(r)
 	for {
 		th, err := tr.Next()
 		if err == io.EOF {
@@ -75,14 +288,24 @@ func extractTarGz(r io.Reader, dst string) error {
 		switch th.Typeflag {
 		case tar.TypeDir:
 		case tar.TypeReg:
+		case tar.TypeSymlink:
+		case tar.TypeLink:
 		default:
 			// TODO: warn
 			continue
 		}
 
-		// Ensure the target path remains rooted at dst and has no `../` escaping outside.
-		path := filepath.Join(dst, th.Name)
-		if !strings.HasPrefix(path, dst) {
+		name, ok := stripPathComponents(th.Name, strip)
+		if !ok {
+			continue
+		}
+		path, err := sanitizeExtractPath(dst, name)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dst, path)
+		// Refuse to write through a symlink extracted earlier that leads outside.
+		if _, err := resolveInRoot(root, filepath.Dir(rel)); err != nil {
 			return fmt.Errorf("failed to sanitize path: %s", th.Name)
 		}
 
@@ -92,16 +315,237 @@ func extractTarGz(r io.Reader, dst string) error {
 				return err
 			}
 		case tar.TypeReg:
-			dir := filepath.Dir(path)
-			if err := os.MkdirAll(dir, 0744); err != nil {
+			if err := prepareExtractPath(path); err != nil {
 				return err
 			}
-
 			if err := copyFile(tr, path, th.FileInfo().Mode()); err != nil {
 				return err
 			}
+		case tar.TypeSymlink:
+			// The target is not cleaned so that `..` is resolved after the
+			// symlinks preceding it, as the kernel would.
+			if filepath.IsAbs(th.Linkname) {
+				return fmt.Errorf("failed to sanitize link target: %s -> %s", th.Name, th.Linkname)
+			}
+			if _, err := resolveInRoot(root, filepath.Dir(rel)+"/"+th.Linkname); err != nil {
+				return fmt.Errorf("failed to sanitize link target: %s -> %s", th.Name, th.Linkname)
+			}
+			if err := prepareExtractPath(path); err != nil {
+				return err
+			}
+			if err := os.Symlink(th.Linkname, path); err != nil {
+				return err
+			}
+			symlinks = append(symlinks, rel)
+		case tar.TypeLink:
+			// The target is another entry, so it is stripped in the same way.
+			linkname, ok := stripPathComponents(th.Linkname, strip)
+			if !ok {
+				return fmt.Errorf("failed to sanitize link target: %s -> %s", th.Name, th.Linkname)
+			}
+			target, err := sanitizeExtractPath(dst, linkname)
+			if err != nil {
+				return fmt.Errorf("failed to sanitize link target: %s -> %s", th.Name, th.Linkname)
+			}
+			targetRel, _ := filepath.Rel(dst, target)
+			if _, err := resolveInRoot(root, filepath.Dir(targetRel)); err != nil {
+				return fmt.Errorf("failed to sanitize link target: %s -> %s", th.Name, th.Linkname)
+			}
+			fi, err := os.Lstat(target)
+			if err != nil {
+				return err
+			}
+			// A hardlink to a symlink is another symlink, whose target is
+			// relative to its own directory.
+			isSymlink := fi.Mode()&os.ModeSymlink != 0
+			if isSymlink {
+				linkname, err := os.Readlink(target)
+				if err != nil {
+					return err
+				}
+				if _, err := resolveInRoot(root, filepath.Dir(rel)+"/"+linkname); err != nil {
+					return fmt.Errorf("failed to sanitize link target: %s -> %s", th.Name, th.Linkname)
+				}
+			}
+			if err := prepareExtractPath(path); err != nil {
+				return err
+			}
+			if err := os.Link(target, path); err != nil {
+				return err
+			}
+			if isSymlink {
+				symlinks = append(symlinks, rel)
+			}
+		}
+	}
+
+	// A symlink may only lead outside once the links it goes through have been
+	// extracted, so check every symlink again against the final tree.
+	for _, rel := range symlinks {
+		if _, err := resolveInRoot(root, rel); err != nil {
+			return fmt.Errorf("failed to sanitize link target: %s", rel)
+		}
+	}
+
+	return nil
+}
+
+// prepareExtractPath creates the parent directories of path and removes a file
+// or a link already at path, so that a new entry never writes through a link.
+func prepareExtractPath(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0744); err != nil {
+		return err
+	}
+	if fi, err := os.Lstat(path); err == nil && !fi.IsDir() {
+		return os.Remove(path)
+	}
+	return nil
+}
+
+// resolveInRoot returns the location that the relative path rel refers to
+// inside root, following symlinks component by component. It fails as soon as
+// the walk leaves root, which must be a path with no symlinks. Components that
+// do not exist yet are resolved lexically.
+func resolveInRoot(root, rel string) (string, error) {
+	cur := root
+	pending := strings.Split(filepath.ToSlash(rel), "/")
+	links := 0
+	for len(pending) > 0 {
+		c := pending[0]
+		pending = pending[1:]
+
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			if cur == root {
+				return "", fmt.Errorf("%s is outside of %s", rel, root)
+			}
+			cur = filepath.Dir(cur)
+			continue
+		}
+
+		next := filepath.Join(cur, c)
+		fi, err := os.Lstat(next)
+		if err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+			cur = next
+			continue
+		}
+
+		links++
+		if links > 255 {
+			return "", fmt.Errorf("too many links in %s", rel)
+		}
+		link, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(link) {
+			return "", fmt.Errorf("%s is outside of %s", rel, root)
+		}
+		pending = append(strings.Split(filepath.ToSlash(link), "/"), pending...)
+	}
+	return cur, nil
+}
+
+// sanitizeExtractPath returns the path to extract an archive entry named name
+// into, ensuring that it remains rooted at dst and has no `../` escaping outside.
+func sanitizeExtractPath(dst, name string) (string, error) {
+	path := filepath.Join(dst, name)
+	if path != filepath.Clean(dst) && !strings.HasPrefix(path, filepath.Clean(dst)+string(filepath.Separator)) {
+		return "", fmt.Errorf("failed to sanitize path: %s", name)
+	}
+	return path, nil
+}
+
+// extractZipStream spools r to a temporary file since the central directory of
+// a zip archive is at its end.
+func extractZipStream(r io.Reader, dst string, strip int) error {
+	f, err := os.CreateTemp("", "gpkg-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+	return extractZip(f, size, dst, strip)
+}
+
+func extractZip(r io.ReaderAt, size int64, dst string, strip int) error {
+	if dst == "" {
+		return errors.New("no destination path provided.")
+	}
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("failed to create zip reader: %s", err)
+	}
+
+	for _, f := range zr.File {
+		mode := zipFileMode(f)
+		switch {
+		case mode.IsDir():
+		case mode.IsRegular():
+		default:
+			// TODO: warn
+			continue
+		}
+
+		name, ok := stripPathComponents(f.Name, strip)
+		if !ok {
+			continue
+		}
+		path, err := sanitizeExtractPath(dst, name)
+		if err != nil {
+			return err
+		}
+
+		if mode.IsDir() {
+			if err := os.MkdirAll(path, 0744); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0744); err != nil {
+			return err
+		}
+		if err := extractZipFile(f, path, mode.Perm()); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
+
+func extractZipFile(f *zip.File, path string, perm fs.FileMode) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s in archive: %s", f.Name, err)
+	}
+	defer rc.Close()
+	return copyFile(rc, path, perm)
+}
+
+// zipFileMode returns the mode of a zip entry. Permission bits are taken from
+// the external attributes of archives created on Unix, and files created
+// elsewhere are assumed to be executable since there is no way to tell.
+func zipFileMode(f *zip.File) fs.FileMode {
+	const (
+		creatorUnix   = 3
+		creatorMacOSX = 19
+	)
+	mode := f.Mode()
+	switch f.CreatorVersion >> 8 {
+	case creatorUnix, creatorMacOSX:
+		return mode
+	}
+	if mode.IsDir() {
+		return mode
+	}
+	return mode&^fs.ModePerm | 0755
+}
@@ -2,20 +2,28 @@ package gpkg
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
 
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
 )
 
-func TestExtractTarGz(t *testing.T) {
+func TestExtract_TarGz(t *testing.T) {
 	t.Run("empty dst", func(t *testing.T) {
 		archive := makeTarGz(t, nil)
-		err := extractTarGz(archive, "")
+		err := extract(archive, "", "foo.tar.gz", extractOptions{})
 		require.Error(t, err)
 	})
 
@@ -33,7 +41,7 @@ func TestExtractTarGz(t *testing.T) {
 		defer os.RemoveAll(dst)
 
 		archive := makeTarGz(t, files)
-		err = extractTarGz(archive, dst)
+		err = extract(archive, dst, "foo.tar.gz", extractOptions{})
 		require.NoError(t, err)
 	})
 
@@ -108,8 +116,8 @@ func TestExtractTarGz(t *testing.T) {
 			[]*tar.Header{
 				{Name: "/../../link", Typeflag: tar.TypeLink},
 			},
-			false,
-			[]string{""},
+			true,
+			nil,
 		},
 		{
 			[]*tar.Header{
@@ -127,15 +135,178 @@ func TestExtractTarGz(t *testing.T) {
 			defer os.RemoveAll(dst)
 
 			archive := makeTarGz(t, tt.files)
-			err = extractTarGz(archive, dst)
+			err = extract(archive, dst, "foo.tar.gz", extractOptions{})
+			if tt.expectedError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assertDirectoryContents(t, dst, tt.expectedFiles)
+			}
+		})
+	}
+}
+
+func TestExtractTar_Links(t *testing.T) {
+	tests := []struct {
+		name          string
+		files         []*tar.Header
+		expectedError bool
+		expectedFiles []string
+	}{
+		{
+			"symlink into a sibling directory",
+			[]*tar.Header{
+				{Name: "libexec/tool", Typeflag: tar.TypeReg, Mode: 0755},
+				{Name: "bin/tool", Typeflag: tar.TypeSymlink, Linkname: "../libexec/tool"},
+			},
+			false,
+			[]string{"", "bin", "bin/tool", "libexec", "libexec/tool"},
+		},
+		{
+			"symlink to a directory",
+			[]*tar.Header{
+				{Name: "lib/v1/foo", Typeflag: tar.TypeReg},
+				{Name: "lib/current", Typeflag: tar.TypeSymlink, Linkname: "v1"},
+			},
+			false,
+			[]string{"", "lib", "lib/current", "lib/v1", "lib/v1/foo"},
+		},
+		{
+			"dangling symlink inside the root",
+			[]*tar.Header{
+				{Name: "bin/tool", Typeflag: tar.TypeSymlink, Linkname: "../libexec/tool"},
+			},
+			false,
+			[]string{"", "bin", "bin/tool"},
+		},
+		{
+			"hardlink",
+			[]*tar.Header{
+				{Name: "bin/tool", Typeflag: tar.TypeReg},
+				{Name: "bin/alias", Typeflag: tar.TypeLink, Linkname: "bin/tool"},
+			},
+			false,
+			[]string{"", "bin", "bin/alias", "bin/tool"},
+		},
+		{
+			"absolute symlink",
+			[]*tar.Header{
+				{Name: "passwd", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+			},
+			true,
+			nil,
+		},
+		{
+			"symlink escaping the root",
+			[]*tar.Header{
+				{Name: "bin/tool", Typeflag: tar.TypeSymlink, Linkname: "../../tool"},
+			},
+			true,
+			nil,
+		},
+		{
+			"symlink escaping the root through another symlink",
+			[]*tar.Header{
+				{Name: "a/b/c/", Typeflag: tar.TypeDir},
+				{Name: "a/b/c/up", Typeflag: tar.TypeSymlink, Linkname: "../.."},
+				{Name: "a/b/c/link", Typeflag: tar.TypeSymlink, Linkname: "up/../../.."},
+			},
+			true,
+			nil,
+		},
+		{
+			"symlink leading outside once a later symlink is extracted",
+			[]*tar.Header{
+				{Name: "a/b/c/", Typeflag: tar.TypeDir},
+				{Name: "a/b/c/link", Typeflag: tar.TypeSymlink, Linkname: "up/../../.."},
+				{Name: "a/b/c/up", Typeflag: tar.TypeSymlink, Linkname: "../.."},
+			},
+			true,
+			nil,
+		},
+		{
+			"write through a symlink",
+			[]*tar.Header{
+				{Name: "dir", Typeflag: tar.TypeSymlink, Linkname: "."},
+				{Name: "dir/../file", Typeflag: tar.TypeReg},
+			},
+			false,
+			[]string{"", "dir", "file"},
+		},
+		{
+			"replace a symlink with a file",
+			[]*tar.Header{
+				{Name: "foo", Typeflag: tar.TypeSymlink, Linkname: "bar"},
+				{Name: "foo", Typeflag: tar.TypeReg},
+			},
+			false,
+			[]string{"", "foo"},
+		},
+		{
+			"hardlink to a symlink",
+			[]*tar.Header{
+				{Name: "libexec/tool", Typeflag: tar.TypeReg},
+				{Name: "bin/tool", Typeflag: tar.TypeSymlink, Linkname: "../libexec/tool"},
+				{Name: "bin/alias", Typeflag: tar.TypeLink, Linkname: "bin/tool"},
+			},
+			false,
+			[]string{"", "bin", "bin/alias", "bin/tool", "libexec", "libexec/tool"},
+		},
+		{
+			"hardlink to a symlink leading outside from another directory",
+			[]*tar.Header{
+				{Name: "a/b/l", Typeflag: tar.TypeSymlink, Linkname: "../../x"},
+				{Name: "l2", Typeflag: tar.TypeLink, Linkname: "a/b/l"},
+			},
+			true,
+			nil,
+		},
+		{
+			"hardlink escaping the root",
+			[]*tar.Header{
+				{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"},
+			},
+			true,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := t.TempDir()
+			dst := filepath.Join(parent, "root")
+			require.NoError(t, os.Mkdir(dst, 0755))
+
+			err := extractTar(makeTar(t, tt.files), dst, 0)
 			if tt.expectedError {
 				require.Error(t, err)
 			} else {
 				require.NoError(t, err)
 				assertDirectoryContents(t, dst, tt.expectedFiles)
 			}
+			// Nothing may be created next to the extraction root.
+			entries, err := os.ReadDir(parent)
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+			assert.Equal(t, "root", entries[0].Name())
 		})
 	}
+
+	t.Run("file contents through a symlink", func(t *testing.T) {
+		dst := t.TempDir()
+		err := extractTar(makeTar(t, []*tar.Header{
+			{Name: "libexec/tool", Typeflag: tar.TypeReg},
+			{Name: "bin/tool", Typeflag: tar.TypeSymlink, Linkname: "../libexec/tool"},
+			{Name: "bin/hard", Typeflag: tar.TypeLink, Linkname: "libexec/tool"},
+		}), dst, 0)
+		require.NoError(t, err)
+
+		for _, name := range []string{"bin/tool", "bin/hard"} {
+			b, err := os.ReadFile(filepath.Join(dst, name))
+			require.NoError(t, err)
+			assert.Equal(t, "libexec/tool", string(b))
+		}
+	})
 }
 
 func TestCopyFile(t *testing.T) {
@@ -151,3 +322,440 @@ func TestCopyFile(t *testing.T) {
 	err = copyFile(br, filepath.Join(t.TempDir(), "bar"), 0644)
 	require.NoError(t, err)
 }
+
+func newZipHeader(name string, mode os.FileMode) *zip.FileHeader {
+	fh := &zip.FileHeader{Name: name}
+	fh.SetMode(mode)
+	return fh
+}
+
+func TestExtractZip(t *testing.T) {
+	t.Run("empty dst", func(t *testing.T) {
+		archive := makeZip(t, nil)
+		err := extractZip(bytes.NewReader(archive.Bytes()), int64(archive.Len()), "", 0)
+		require.Error(t, err)
+	})
+
+	tests := []struct {
+		files         []*zip.FileHeader
+		expectedError bool
+		expectedFiles []string
+	}{
+		{
+			[]*zip.FileHeader{newZipHeader("../test/path/", os.ModeDir|0755)},
+			true,
+			nil,
+		},
+		{
+			[]*zip.FileHeader{newZipHeader("test/../../path", 0644)},
+			true,
+			nil,
+		},
+		{
+			[]*zip.FileHeader{newZipHeader("/../../file.ext", 0644)},
+			true,
+			nil,
+		},
+		{
+			[]*zip.FileHeader{newZipHeader("test/", os.ModeDir|0755)},
+			false,
+			[]string{"", "test"},
+		},
+		{
+			[]*zip.FileHeader{
+				newZipHeader("test/", os.ModeDir|0755),
+				newZipHeader("test/path/", os.ModeDir|0755),
+				newZipHeader("test/path/file.ext", 0644),
+			},
+			false,
+			[]string{"", "test", "test/path", "test/path/file.ext"},
+		},
+		{
+			// Parent directories are created even if they are not in the archive.
+			[]*zip.FileHeader{newZipHeader("test/path/file.ext", 0644)},
+			false,
+			[]string{"", "test", "test/path", "test/path/file.ext"},
+		},
+		{
+			[]*zip.FileHeader{newZipHeader("link", os.ModeSymlink|0777)},
+			false,
+			[]string{""},
+		},
+		{
+			[]*zip.FileHeader{newZipHeader("..file", 0644)},
+			false,
+			[]string{"", "..file"},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test-%02d", i), func(t *testing.T) {
+			dst := t.TempDir()
+			archive := makeZip(t, tt.files)
+			err := extractZip(bytes.NewReader(archive.Bytes()), int64(archive.Len()), dst, 0)
+			if tt.expectedError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assertDirectoryContents(t, dst, tt.expectedFiles)
+			}
+		})
+	}
+}
+
+func TestExtractZip_Permission(t *testing.T) {
+	nonUnix := &zip.FileHeader{Name: "windows.exe"}
+	nonUnix.CreatorVersion = 0
+
+	dst := t.TempDir()
+	archive := makeZip(t, []*zip.FileHeader{
+		newZipHeader("bin/tool", 0755),
+		newZipHeader("README", 0644),
+		newZipHeader("secret", 0600),
+		nonUnix,
+	})
+	err := extractZip(bytes.NewReader(archive.Bytes()), int64(archive.Len()), dst, 0)
+	require.NoError(t, err)
+
+	for name, expected := range map[string]os.FileMode{
+		"bin/tool":    0755,
+		"README":      0644,
+		"secret":      0600,
+		"windows.exe": 0755,
+	} {
+		fi, err := os.Stat(filepath.Join(dst, name))
+		require.NoError(t, err)
+		assert.Equal(t, expected, fi.Mode().Perm(), name)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name          string
+		asset         string
+		archive       *bytes.Buffer
+		expectedFiles []string
+	}{
+		{
+			"tar.gz",
+			"foo.tar.gz",
+			makeTarGz(t, []*tar.Header{{Name: "bin/foo", Typeflag: tar.TypeReg}}),
+			[]string{"", "bin", "bin/foo"},
+		},
+		{
+			"zip",
+			"foo.zip",
+			makeZip(t, []*zip.FileHeader{newZipHeader("bin/foo", 0755)}),
+			[]string{"", "bin", "bin/foo"},
+		},
+		{
+			"tar",
+			"foo.tar",
+			makeTar(t, []*tar.Header{{Name: "bin/foo", Typeflag: tar.TypeReg}}),
+			[]string{"", "bin", "bin/foo"},
+		},
+		{
+			"plain file",
+			"foo",
+			bytes.NewBufferString("#!/bin/sh\n"),
+			[]string{"", "foo"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			err := extract(tt.archive, dst, tt.asset, extractOptions{})
+			require.NoError(t, err)
+			assertDirectoryContents(t, dst, tt.expectedFiles)
+		})
+	}
+}
+
+func TestExtract_StripComponents(t *testing.T) {
+	tests := []struct {
+		name          string
+		asset         string
+		archive       *bytes.Buffer
+		strip         int
+		expectedFiles []string
+	}{
+		{
+			"tar",
+			"foo.tar.gz",
+			makeTarGz(t, []*tar.Header{
+				{Name: "foo-v1.0.0/", Typeflag: tar.TypeDir},
+				{Name: "foo-v1.0.0/bin/foo", Typeflag: tar.TypeReg},
+				{Name: "foo-v1.0.0/bin/bar", Typeflag: tar.TypeLink, Linkname: "foo-v1.0.0/bin/foo"},
+			}),
+			1,
+			[]string{"", "bin", "bin/bar", "bin/foo"},
+		},
+		{
+			"leading dot",
+			"foo.tar",
+			makeTar(t, []*tar.Header{
+				{Name: "./foo-v1.0.0/bin/foo", Typeflag: tar.TypeReg},
+			}),
+			1,
+			[]string{"", "bin", "bin/foo"},
+		},
+		{
+			"zip",
+			"foo.zip",
+			makeZip(t, []*zip.FileHeader{
+				newZipHeader("foo-v1.0.0/", os.ModeDir|0755),
+				newZipHeader("foo-v1.0.0/bin/foo", 0755),
+			}),
+			1,
+			[]string{"", "bin", "bin/foo"},
+		},
+		{
+			"entries without enough components are skipped",
+			"foo.tar",
+			makeTar(t, []*tar.Header{
+				{Name: "README", Typeflag: tar.TypeReg},
+				{Name: "foo-v1.0.0/bin/foo", Typeflag: tar.TypeReg},
+			}),
+			2,
+			[]string{"", "foo"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			err := extract(tt.archive, dst, tt.asset, extractOptions{stripComponents: tt.strip})
+			require.NoError(t, err)
+			assertDirectoryContents(t, dst, tt.expectedFiles)
+		})
+	}
+
+	t.Run("hard link to a stripped entry", func(t *testing.T) {
+		err := extractTar(makeTar(t, []*tar.Header{
+			{Name: "top", Typeflag: tar.TypeReg},
+			{Name: "foo-v1.0.0/foo", Typeflag: tar.TypeLink, Linkname: "top"},
+		}), t.TempDir(), 1)
+		require.Error(t, err)
+	})
+}
+
+func TestExtract_StripTopDir(t *testing.T) {
+	tests := []struct {
+		name          string
+		asset         string
+		archive       *bytes.Buffer
+		expectedFiles []string
+		recvErr       bool
+	}{
+		{
+			"single top-level directory",
+			"foo.tar.gz",
+			makeTarGz(t, []*tar.Header{
+				{Name: "foo-v1.0.0/bin/foo", Typeflag: tar.TypeReg},
+				{Name: "foo-v1.0.0/README", Typeflag: tar.TypeReg},
+			}),
+			[]string{"", "README", "bin", "bin/foo"},
+			false,
+		},
+		{
+			"directory named after the top-level directory",
+			"foo.zip",
+			makeZip(t, []*zip.FileHeader{
+				newZipHeader("foo/foo/foo", 0755),
+			}),
+			[]string{"", "foo", "foo/foo"},
+			false,
+		},
+		{
+			"multiple top-level entries",
+			"foo.tar",
+			makeTar(t, []*tar.Header{
+				{Name: "foo-v1.0.0/bin/foo", Typeflag: tar.TypeReg},
+				{Name: "README", Typeflag: tar.TypeReg},
+			}),
+			[]string{"", "README", "foo-v1.0.0", "foo-v1.0.0/bin", "foo-v1.0.0/bin/foo"},
+			false,
+		},
+		{
+			"plain file",
+			"foo",
+			bytes.NewBufferString("#!/bin/sh\n"),
+			[]string{"", "foo"},
+			false,
+		},
+		{
+			"symlink leading outside once moved up",
+			"foo.tar",
+			makeTar(t, []*tar.Header{
+				{Name: "foo-v1.0.0/link", Typeflag: tar.TypeSymlink, Linkname: "../foo-v1.0.0"},
+			}),
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			err := extract(tt.archive, dst, tt.asset, extractOptions{stripTopDir: true})
+			if tt.recvErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assertDirectoryContents(t, dst, tt.expectedFiles)
+		})
+	}
+}
+
+// tarBz2Fixture is a bzip2-compressed archive containing bin/ and bin/foo,
+// since the standard library has no bzip2 writer.
+const tarBz2Fixture = "QlpoOTFBWSZTWXN67B0AAIj7gMmAACBAAPuAACRxIZ4ACAggAHUJKT1BoNB6QGgJJRkaaAAaHqP3LGagkBCKSEYxoMSCejFxaIQwDKiyYExAEPNhDF8XLnrJ2FrwiKhv8cHaouSh6OOOYCQfi7kinChIOb12DoA="
+
+func compressTestArchive(t *testing.T, c *compression, archive *bytes.Buffer) *bytes.Buffer {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch c {
+	case gzipCompression:
+		w = gzip.NewWriter(&buf)
+	case xzCompression:
+		w, err = xz.NewWriter(&buf)
+	case zstdCompression:
+		w, err = zstd.NewWriter(&buf)
+	case bzip2Compression:
+		b, err := base64.StdEncoding.DecodeString(tarBz2Fixture)
+		require.NoError(t, err)
+		return bytes.NewBuffer(b)
+	}
+	require.NoError(t, err)
+	_, err = io.Copy(w, archive)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return &buf
+}
+
+func TestExtract_CompressedTar(t *testing.T) {
+	for _, c := range compressions {
+		for _, name := range []string{"foo.tar" + c.extensions[0], "foo"} {
+			t.Run(fmt.Sprintf("%s/%s", c.name, name), func(t *testing.T) {
+				archive := compressTestArchive(t, c, makeTar(t, []*tar.Header{
+					{Name: "bin", Typeflag: tar.TypeDir},
+					{Name: "bin/foo", Typeflag: tar.TypeReg},
+				}))
+
+				dst := t.TempDir()
+				err := extract(archive, dst, name, extractOptions{})
+				require.NoError(t, err)
+				assertDirectoryContents(t, dst, []string{"", "bin", "bin/foo"})
+
+				b, err := os.ReadFile(filepath.Join(dst, "bin/foo"))
+				require.NoError(t, err)
+				assert.Equal(t, "bin/foo", string(b))
+			})
+		}
+	}
+
+	t.Run("corrupted", func(t *testing.T) {
+		err := extract(bytes.NewBufferString("\xfd7zXZ\x00corrupted"), t.TempDir(), "foo.tar.xz", extractOptions{})
+		require.Error(t, err)
+	})
+}
+
+func TestDetectCompression(t *testing.T) {
+	tests := []struct {
+		mime     string
+		name     string
+		expected *compression
+	}{
+		{"application/gzip", "foo", gzipCompression},
+		{"application/x-xz", "foo.tar.gz", xzCompression},
+		{"application/x-bzip2", "foo", bzip2Compression},
+		{"application/zstd", "foo", zstdCompression},
+		{"", "foo.tgz", gzipCompression},
+		{"", "foo.tar.xz", xzCompression},
+		{"", "foo.TAR.BZ2", bzip2Compression},
+		{"", "foo.tbz", bzip2Compression},
+		{"", "foo.tar.zst", zstdCompression},
+		{"", "foo", nil},
+		{"application/zip", "foo.tar.gz", nil},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%s", tt.mime, tt.name), func(t *testing.T) {
+			assert.Equal(t, tt.expected, detectCompression(tt.mime, tt.name))
+		})
+	}
+}
+
+func TestExtract_CompressedFile(t *testing.T) {
+	for _, c := range []*compression{gzipCompression, xzCompression, zstdCompression} {
+		tests := []struct {
+			name     string
+			expected string
+		}{
+			{"tool-linux-amd64" + c.extensions[0], "tool-linux-amd64"},
+			{"tool-linux-amd64" + strings.ToUpper(c.extensions[0]), "tool-linux-amd64"},
+			{"tool-linux-amd64", "tool-linux-amd64"},
+		}
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/%s", c.name, tt.name), func(t *testing.T) {
+				contents := "#!/bin/sh\necho foo\n"
+				archive := compressTestArchive(t, c, bytes.NewBufferString(contents))
+
+				dst := t.TempDir()
+				err := extract(archive, dst, tt.name, extractOptions{})
+				require.NoError(t, err)
+				assertDirectoryContents(t, dst, []string{"", tt.expected})
+
+				p := filepath.Join(dst, tt.expected)
+				b, err := os.ReadFile(p)
+				require.NoError(t, err)
+				assert.Equal(t, contents, string(b))
+				fi, err := os.Stat(p)
+				require.NoError(t, err)
+				assert.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+			})
+		}
+	}
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestExtract_Streaming(t *testing.T) {
+	files := []*tar.Header{{Name: "bin/foo", Typeflag: tar.TypeReg}}
+	padded := makeTar(t, files)
+	padded.Write(make([]byte, 10240))
+
+	tests := []struct {
+		name    string
+		asset   string
+		archive *bytes.Buffer
+	}{
+		{"tar", "foo.tar", makeTar(t, files)},
+		{"tar with record padding", "foo.tar", padded},
+		{"tar.gz", "foo.tar.gz", makeTarGz(t, files)},
+		{"tar.xz", "foo.tar.xz", compressTestArchive(t, xzCompression, makeTar(t, files))},
+		{"zip", "foo.zip", makeZip(t, []*zip.FileHeader{newZipHeader("bin/foo", 0755)})},
+		{"gz", "foo.gz", compressTestArchive(t, gzipCompression, bytes.NewBufferString("foo"))},
+		{"plain file larger than the sniffed prefix", "foo", bytes.NewBuffer(make([]byte, 3*sniffLen+1))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size := tt.archive.Len()
+			// Read one byte at a time to make sure nothing relies on a single Read.
+			r := &countingReader{r: iotest.OneByteReader(tt.archive)}
+
+			err := extract(r, t.TempDir(), tt.asset, extractOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, size, r.n, "the whole stream should be consumed")
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package gpkg
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	cp "github.com/otiai10/copy"
+)
+
+// currentVersionName is the name of the symlink in the directory of a package
+// which points to the version in use. The version is switched by replacing the
+// link, so the package is never seen half installed.
+const currentVersionName = "current"
+
+// InstalledVersion is a version of a package which is kept installed.
+type InstalledVersion struct {
+	Ref         string     `json:"ref"`
+	SHA256      string     `json:"sha256,omitempty"`
+	InstalledAt *time.Time `json:"installed_at,omitempty"`
+}
+
+// versionDirName returns the name of the directory ref is installed to under
+// the directory of a package.
+func versionDirName(ref string) string {
+	name := url.PathEscape(ref)
+	if name == "" || name == currentVersionName || strings.HasPrefix(name, ".") {
+		name = "_" + name
+	}
+	return name
+}
+
+// packageDir returns the directory holding every version of the package
+// installed as st. A package installed by an older version of gpkg has no
+// versions, and st.Path is the directory itself.
+func packageDir(st State) string {
+	if filepath.Base(st.Path) == currentVersionName {
+		return filepath.Dir(st.Path)
+	}
+	return st.Path
+}
+
+// installVersion copies the files in src to the directory of ref under root,
+// and makes it the current version.
+func installVersion(root, ref, src string) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	staging, err := os.MkdirTemp(root, ".install-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	if err := cp.Copy(src, staging); err != nil {
+		return err
+	}
+
+	// The same ref is installed again when its asset has changed.
+	dir := filepath.Join(root, versionDirName(ref))
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.Rename(staging, dir); err != nil {
+		return err
+	}
+	return setCurrentVersion(root, ref)
+}
+
+// setCurrentVersion points the current version of the package under root to
+// ref, which is installed.
+func setCurrentVersion(root, ref string) error {
+	link := filepath.Join(root, currentVersionName)
+	// A file left by an older version of gpkg cannot be replaced by a rename.
+	if fi, err := os.Lstat(link); err == nil && fi.Mode()&os.ModeSymlink == 0 {
+		if err := os.RemoveAll(link); err != nil {
+			return err
+		}
+	}
+
+	tmp := filepath.Join(root, ".current")
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.Symlink(versionDirName(ref), tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, link)
+}
+
+// pruneVersions removes the versions of spec installed before the last keep
+// ones, including the current version, and any other file in the directory of
+// the package, such as the files of a package installed by an older version of
+// gpkg.
+func pruneVersions(states *StateData, spec PackageSpec, keep int) error {
+	root := spec.PackagePath()
+	kept := map[string]bool{currentVersionName: true}
+
+	err := states.update(spec, func(st *State) {
+		kept[versionDirName(st.Ref)] = true
+		var previous []InstalledVersion
+		for _, v := range st.Previous {
+			if len(previous) >= keep-1 {
+				break
+			}
+			if _, err := os.Stat(filepath.Join(root, versionDirName(v.Ref))); err != nil {
+				continue
+			}
+			kept[versionDirName(v.Ref)] = true
+			previous = append(previous, v)
+		}
+		st.Previous = previous
+	})
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if kept[e.Name()] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(root, e.Name())); err != nil {
+			return fmt.Errorf("Failed to remove an old version. err=%v", err)
+		}
+	}
+	return nil
+}
+
+// RollbackPackage switches the package installed for spec back to ref, which
+// is one of the versions kept installed before the current one. The version
+// installed last before the current one is used if ref is empty.
+func RollbackPackage(states *StateData, spec PackageSpec, ref string) (*State, error) {
+	_, st, err := states.FindState(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not installed", spec.DisplayName())
+	}
+	if filepath.Base(st.Path) != currentVersionName {
+		return nil, fmt.Errorf("No previous version of %s is kept. Run gpkg update to install it with versions", spec.DisplayName())
+	}
+	if ref == st.Ref {
+		return nil, fmt.Errorf("%s is already at %s", spec.DisplayName(), ref)
+	}
+
+	idx := -1
+	for i, v := range st.Previous {
+		if ref == "" || v.Ref == ref {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		if ref == "" {
+			return nil, fmt.Errorf("No previous version of %s is kept", spec.DisplayName())
+		}
+		refs := make([]string, len(st.Previous))
+		for i, v := range st.Previous {
+			refs[i] = v.Ref
+		}
+		return nil, fmt.Errorf("%s is not kept. Versions kept: %s", ref, strings.Join(refs, ", "))
+	}
+	target := st.Previous[idx]
+
+	root := packageDir(*st)
+	if _, err := os.Stat(filepath.Join(root, versionDirName(target.Ref))); err != nil {
+		return nil, fmt.Errorf("%s is missing. err=%v", target.Ref, err)
+	}
+	if err := setCurrentVersion(root, target.Ref); err != nil {
+		return nil, err
+	}
+
+	var rolledBack State
+	err = states.update(spec, func(st *State) {
+		previous := []InstalledVersion{{Ref: st.Ref, SHA256: st.SHA256, InstalledAt: st.InstalledAt}}
+		for _, v := range st.Previous {
+			if v.Ref != target.Ref {
+				previous = append(previous, v)
+			}
+		}
+		st.Ref = target.Ref
+		st.SHA256 = target.SHA256
+		st.InstalledAt = target.InstalledAt
+		st.Previous = previous
+		rolledBack = *st
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rolledBack, nil
+}
@@ -0,0 +1,137 @@
+package gpkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionDirName(t *testing.T) {
+	tests := []struct {
+		ref      string
+		expected string
+	}{
+		{"v1.0.0", "v1.0.0"},
+		{"release/1.0", "release%2F1.0"},
+		{"..", "_.."},
+		{"current", "_current"},
+		{"", "_"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, versionDirName(tt.ref), tt.ref)
+	}
+}
+
+// newVersionsTestSpec returns a function which makes a spec of the given
+// release served by a Gitea stand-in. Each release has a binary for the host
+// named after its tag.
+func newVersionsTestSpec(t *testing.T, keep int) func(ref string) *GiteaReleaseSpec {
+	var releases []*giteaRelease
+	for _, tag := range []string{"v1.2.0", "v1.1.0", "v1.0.0"} {
+		releases = append(releases, newGiteaTestRelease(tag, fmt.Sprintf("foo-%s-%s-%s", tag, runtime.GOOS, runtime.GOARCH)))
+	}
+	srv := newGiteaTestServer(t, "owner/foo", "", releases)
+	cfg := &Config{CachePath: t.TempDir(), KeepVersions: keep}
+	return func(ref string) *GiteaReleaseSpec {
+		return &GiteaReleaseSpec{
+			CommonSpec: &CommonSpec{From: "gitea", Ref: ref, config: cfg},
+			Host:       srv.URL,
+			Repo:       "owner/foo",
+		}
+	}
+}
+
+// assertCurrentVersion asserts that the binary of ref is the one in use.
+func assertCurrentVersion(t *testing.T, spec PackageSpec, ref string) {
+	t.Helper()
+	name := fmt.Sprintf("foo-%s-%s-%s", ref, runtime.GOOS, runtime.GOARCH)
+	b, err := os.ReadFile(filepath.Join(spec.PackagePath(), currentVersionName, name))
+	require.NoError(t, err)
+	assert.Equal(t, name, string(b))
+}
+
+func TestReconcilePackage_KeepVersions(t *testing.T) {
+	specAt := newVersionsTestSpec(t, 2)
+	states := &StateData{}
+	for _, ref := range []string{"v1.0.0", "v1.1.0", "v1.2.0"} {
+		require.NoError(t, reconcileTestPackage(states, specAt(ref)))
+		assertCurrentVersion(t, specAt(ref), ref)
+	}
+
+	spec := specAt("v1.2.0")
+	_, st, err := states.FindState(spec)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(spec.PackagePath(), currentVersionName), st.Path)
+	require.Len(t, st.Previous, 1)
+	assert.Equal(t, "v1.1.0", st.Previous[0].Ref)
+	assert.Equal(t, sha256Hex(fmt.Sprintf("foo-v1.1.0-%s-%s", runtime.GOOS, runtime.GOARCH)), st.Previous[0].SHA256)
+
+	entries, err := os.ReadDir(spec.PackagePath())
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.ElementsMatch(t, []string{currentVersionName, "v1.1.0", "v1.2.0"}, names)
+}
+
+func TestReconcilePackage_OldLayout(t *testing.T) {
+	specAt := newVersionsTestSpec(t, 2)
+	spec := specAt("v1.0.0")
+	require.NoError(t, os.MkdirAll(spec.PackagePath(), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(spec.PackagePath(), "foo"), nil, 0755))
+	states := &StateData{States: []State{{Spec: spec, Path: spec.PackagePath(), Ref: "v0.9.0"}}}
+
+	_, err := RollbackPackage(states, spec, "")
+	require.Error(t, err)
+
+	require.NoError(t, reconcileTestPackage(states, spec))
+	assertCurrentVersion(t, spec, "v1.0.0")
+	assert.NoFileExists(t, filepath.Join(spec.PackagePath(), "foo"))
+	_, st, err := states.FindState(spec)
+	require.NoError(t, err)
+	assert.Empty(t, st.Previous)
+}
+
+func TestRollbackPackage(t *testing.T) {
+	specAt := newVersionsTestSpec(t, 3)
+	states := &StateData{}
+	for _, ref := range []string{"v1.0.0", "v1.1.0", "v1.2.0"} {
+		require.NoError(t, reconcileTestPackage(states, specAt(ref)))
+	}
+	spec := specAt("latest")
+
+	st, err := RollbackPackage(states, spec, "")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.1.0", st.Ref)
+	assertCurrentVersion(t, spec, "v1.1.0")
+	_, saved, err := states.FindState(spec)
+	require.NoError(t, err)
+	assert.Equal(t, "v1.1.0", saved.Ref)
+	assert.Equal(t, sha256Hex(fmt.Sprintf("foo-v1.1.0-%s-%s", runtime.GOOS, runtime.GOARCH)), saved.SHA256)
+	assert.Equal(t, []string{"v1.2.0", "v1.0.0"}, []string{saved.Previous[0].Ref, saved.Previous[1].Ref})
+
+	st, err = RollbackPackage(states, spec, "v1.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.0.0", st.Ref)
+	assertCurrentVersion(t, spec, "v1.0.0")
+
+	// Back to the newest
+	_, err = RollbackPackage(states, spec, "v1.2.0")
+	require.NoError(t, err)
+	assertCurrentVersion(t, spec, "v1.2.0")
+
+	for _, ref := range []string{"v1.2.0", "v0.1.0"} {
+		_, err = RollbackPackage(states, spec, ref)
+		assert.Error(t, err, ref)
+	}
+	assertCurrentVersion(t, spec, "v1.2.0")
+
+	_, err = RollbackPackage(&StateData{}, spec, "")
+	assert.Error(t, err)
+}